@@ -1,13 +1,24 @@
 package bmux
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/etwodev/bmux/internal/logwriter"
+	"github.com/etwodev/bmux/pkg/clock"
 	"github.com/etwodev/bmux/pkg/config"
 	"github.com/etwodev/bmux/pkg/engine"
 	"github.com/etwodev/bmux/pkg/handler"
@@ -17,10 +28,13 @@ import (
 	"github.com/rs/zerolog"
 )
 
-var log = zerolog.New(zerolog.ConsoleWriter{
+// logOut is the sink of the package logger, swapped by setLogWriter.
+var logOut = logwriter.New(zerolog.ConsoleWriter{
 	Out:        os.Stdout,
 	TimeFormat: "2006-01-02T15:04:05",
-}).With().Timestamp().Str("Group", "bmux-wrapper").Logger()
+})
+
+var log = zerolog.New(logOut).With().Timestamp().Str("Group", "bmux-wrapper").Logger()
 
 // Server represents the bmux server instance.
 // It manages routers, middleware, and the underlying event engine.
@@ -40,14 +54,43 @@ var log = zerolog.New(zerolog.ConsoleWriter{
 //
 // The server handles connections using gnet for high-performance async I/O.
 type Server[T any] struct {
-	engineWrapper *engine.EngineWrapper[T]
-	routers       []router.Router
-	middleware    []middleware.Middleware
+	engineWrapper  *engine.EngineWrapper[T]
+	routers        []router.Router
+	middleware     []middleware.Middleware
+	mwStatus       []*atomic.Bool // toggle status of each middleware, by index
+	shutdownHooks  []func(context.Context) error
+	gnetOptions    []gnet.Option
+	configPath     string
+	transport      engine.Transport
+	healthAddr     string
+	health         *http.Server
+	stopping       atomic.Bool
+	goodbye        []byte
+	routeErrors    atomic.Pointer[map[int]router.ErrorCounter]
+	expectedRoutes int
+	signals        []os.Signal
+	registerMu     sync.Mutex
+	chains         map[chainKey]composedChain // guarded by registerMu
 }
 
 // Option defines a functional option to customize the Server.
+//
+// Options are applied before the configuration is loaded, so they must not
+// read config values themselves.
 type Option[T any] func(*Server[T])
 
+// WithConfigPath loads the configuration from path instead of the default
+// CONFIG_PATH ("./bmux.config.json").
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithConfigPath[MyContext]("/etc/bmux/config.json"))
+func WithConfigPath[T any](path string) Option[T] {
+	return func(s *Server[T]) {
+		s.configPath = path
+	}
+}
+
 // New creates a new bmux Server instance with the given context factory,
 // length extractor, message ID extractor, optional config override, and options.
 //
@@ -81,46 +124,123 @@ func New[T any](
 		log.Fatal().Str("Function", "New").Msg("extractMsgID cannot be nil")
 	}
 
-	if err := config.New(override); err != nil {
-		log.Fatal().Str("Function", "New").Err(err).Msg("failed to load config")
-	}
-
-	level, err := zerolog.ParseLevel(config.LogLevel())
-	if err != nil {
-		level = zerolog.InfoLevel
-	}
-	zerolog.SetGlobalLevel(level)
-
 	engineWrapper := &engine.EngineWrapper[T]{
 		ContextFactory: contextFactory,
 		ExtractLength:  extractLength,
 		ExtractMsgID:   extractMsgID,
-		HeadSize:       config.HeadSize(),
-		MaxConnections: int64(config.MaxConnections()),
-		Handlers:       make(map[int]handler.HandlerFunc),
 	}
 
 	s := &Server[T]{
 		engineWrapper: engineWrapper,
+		configPath:    config.CONFIG_PATH,
+		transport:     engine.NewGnetTransport(),
+		signals:       []os.Signal{os.Interrupt, syscall.SIGTERM},
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	if err := config.NewFrom(s.configPath, override); err != nil {
+		log.Fatal().Str("Function", "New").Err(err).Msg("failed to load config")
+	}
+
+	w, err := newLogWriter(config.LogFormat(), config.LogOutput())
+	if err != nil {
+		log.Fatal().Str("Function", "New").Err(err).Msg("failed to build logger")
+	}
+	setLogWriter(w)
+
+	level, err := zerolog.ParseLevel(config.LogLevel())
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(level)
+
+	// Logged here rather than by the config package, since the log level is
+	// only known once the config has been loaded.
+	stats := config.LastLoad()
+	log.Debug().
+		Str("Path", stats.Path).
+		Int64("Size", stats.Size).
+		Dur("Took", stats.Took).
+		Msg("configuration loaded")
+
+	engineWrapper.HeadSize = config.HeadSize()
+	engineWrapper.MaxConnections = int64(config.MaxConnections())
+	engineWrapper.MaxWriteFailures = int64(config.MaxWriteFailures())
+	engineWrapper.LogHandlerCloses = config.LogHandlerCloses()
+	engineWrapper.IdleTimeout = time.Duration(config.IdleTimeout()) * time.Second
+	engineWrapper.IdleWarningTimeout = time.Duration(config.IdleWarningTimeout()) * time.Second
+
+	engineWrapper.WriteCoalesceWindow = time.Duration(config.WriteCoalesceWindow()) * time.Microsecond
+	engineWrapper.WriteCoalesceBytes = config.WriteCoalesceBytes()
+	engineWrapper.MaxFramesPerSecond = config.MaxFramesPerSecond()
+	engineWrapper.CloseOnFrameLimit = config.CloseOnFrameLimit()
+	engineWrapper.MaxConcurrentHandlers = config.MaxConcurrentHandlers()
+	engineWrapper.HandlerQueueWait = time.Duration(config.HandlerQueueWait()) * time.Millisecond
+	engineWrapper.MaxAcceptsPerSecond = config.MaxAcceptsPerSecond()
+	engineWrapper.AcceptBurst = config.AcceptBurst()
+	engineWrapper.MaxPendingInbound = config.MaxPendingInbound()
+	engineWrapper.WarnLogBurst = config.WarnLogBurst()
+	engineWrapper.WarnLogSample = config.WarnLogSample()
+	engineWrapper.WarnLogWindow = time.Duration(config.WarnLogWindow()) * time.Second
+	engineWrapper.EnableSOLinger = config.EnableSOLinger()
+	engineWrapper.SOLinger = config.SOLinger()
+
+	if engineWrapper.IdleWarningTimeout > 0 && engineWrapper.IdleWarningTimeout >= engineWrapper.IdleTimeout {
+		log.Warn().
+			Str("Function", "New").
+			Int("IdleTimeout", config.IdleTimeout()).
+			Int("IdleWarningTimeout", config.IdleWarningTimeout()).
+			Msg("idleWarningTimeout must be below idleTimeout, disabling idle warnings")
+
+		engineWrapper.IdleWarningTimeout = 0
+	}
+
 	return s
 }
 
 // LoadRouter appends one or more routers to the server.
 //
-// Routers contain groups of routes and their associated middleware.
+// Routers contain groups of routes and their associated middleware. A
+// router that is already loaded, i.e. one serving the very same routes and
+// middleware slices, is skipped with a warning rather than registered twice.
 //
 // Example:
 //
 //	myRouter := router.NewRouter(true, routes, middleware, opts...)
 //	server.LoadRouter([]router.Router{myRouter})
 func (s *Server[T]) LoadRouter(routes []router.Router) {
-	s.routers = append(s.routers, routes...)
+	for _, rtr := range routes {
+		if slices.ContainsFunc(s.routers, func(loaded router.Router) bool { return sameRouter(loaded, rtr) }) {
+			log.Warn().
+				Str("Function", "LoadRouter").
+				Int("Routes", len(rtr.Routes())).
+				Msg("router already loaded, skipping")
+			continue
+		}
+
+		s.routers = append(s.routers, rtr)
+	}
+}
+
+// sameRouter reports whether a and b are the same router. Routers are often
+// values holding slices, which cannot be compared with ==, so identity is
+// judged by what they serve: the same routes and middleware backing arrays
+// and lengths, and the same status. Routers without routes register nothing
+// and are never considered the same.
+func sameRouter(a, b router.Router) bool {
+	ra, rb := a.Routes(), b.Routes()
+	if len(ra) == 0 || len(ra) != len(rb) || &ra[0] != &rb[0] {
+		return false
+	}
+
+	ma, mb := a.Middleware(), b.Middleware()
+	if len(ma) != len(mb) || (len(ma) > 0 && &ma[0] != &mb[0]) {
+		return false
+	}
+	return a.Status() == b.Status()
 }
 
 // LoadMiddleware appends global middleware to the server.
@@ -131,20 +251,96 @@ func (s *Server[T]) LoadRouter(routes []router.Router) {
 //
 //	server.LoadMiddleware([]middleware.Middleware{myMiddleware})
 func (s *Server[T]) LoadMiddleware(middleware []middleware.Middleware) {
+	for _, mw := range middleware {
+		status := &atomic.Bool{}
+		status.Store(mw.Status())
+		s.mwStatus = append(s.mwStatus, status)
+	}
 	s.middleware = append(s.middleware, middleware...)
 }
 
+// UseOnConnect registers fn to run once when a connection opens, before
+// any of its messages are handled, e.g. for session setup. Returning an
+// error refuses the connection, which is then closed.
+//
+// Hooks run in registration order on the connection's event loop, after
+// the connection has its context and ID, and must not block.
+//
+// Example:
+//
+//	server.UseOnConnect(func(c gnet.Conn) error {
+//		if banned(c.RemoteAddr()) {
+//			return errors.New("banned")
+//		}
+//		return nil
+//	})
+func (s *Server[T]) UseOnConnect(fn func(c gnet.Conn) error) {
+	s.engineWrapper.OnConnect = append(s.engineWrapper.OnConnect, fn)
+}
+
+// SetMiddlewareStatus enables or disables every global middleware with the
+// given name at runtime, without re-registering routes.
+//
+// The initial status of each middleware comes from its own Status(), even
+// when several share a name. Because toggling
+// is possible, every global middleware stays in the composed chain and its
+// status is checked with an atomic load on each request; a disabled
+// middleware costs that load plus one extra call frame.
+//
+// Returns an error if no middleware with that name has been loaded.
+//
+// Example:
+//
+//	err := server.SetMiddlewareStatus("connection_logger", false)
+func (s *Server[T]) SetMiddlewareStatus(name string, enabled bool) error {
+	found := false
+	for i, mw := range s.middleware {
+		if mw.Name() == name {
+			s.mwStatus[i].Store(enabled)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("SetMiddlewareStatus: no middleware named %q", name)
+	}
+	return nil
+}
+
 // registerRoutes composes middleware chains and registers handlers
 // from routers and routes into the engine's handler map.
 //
+// Routes are registered in ascending Priority order (load order within a
+// priority), so when two routes share an ID the higher priority one wins,
+// and between equal priorities the one loaded last wins.
+//
+// Ranged routes are registered under every ID of their range, unless it is
+// wider than router.MaxExpandedRange. Such ranges and Matcher routes (e.g.
+// NewMaskRoute) are only consulted for IDs without a route, following the
+// same precedence.
+//
+// It returns the number of routes registered, counting a ranged or matcher
+// route once.
+//
 // This method is invoked once automatically on server Start().
-func (s *Server[T]) registerRoutes() {
+func (s *Server[T]) registerRoutes() int {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+
+	type entry struct {
+		rtr      router.Router
+		rt       router.Route
+		slot     *router.Route
+		priority int
+	}
+
+	var entries []entry
 	for _, rtr := range s.routers {
 		if !rtr.Status() {
 			continue
 		}
 
-		for _, rt := range rtr.Routes() {
+		routes := rtr.Routes()
+		for i, rt := range routes {
 			if !rt.Status() {
 				continue
 			}
@@ -153,87 +349,435 @@ func (s *Server[T]) registerRoutes() {
 				continue
 			}
 
-			handler := rt.Handler()
+			entries = append(entries, entry{rtr, rt, &routes[i], routePriority(rt)})
+		}
+	}
 
-			// Route-level middleware (innermost) - wrapped first, so runs last
-			for i := len(rt.Middleware()) - 1; i >= 0; i-- {
-				handler = rt.Middleware()[i](handler)
-			}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
 
-			// Router-level middleware
-			for i := len(rt.Middleware()) - 1; i >= 0; i-- {
-				handler = rt.Middleware()[i](handler)
-			}
+	size := max(len(entries), s.expectedRoutes)
+	registered := make(map[int]entry, size)
+	handlers := newHandlerTable(size)
+	keys := make(map[int]int)
+	var matchers []engine.Matcher
+	prev := s.chains
+	s.chains = make(map[chainKey]composedChain, len(entries))
+	for _, e := range entries {
+		rt := e.rt
+		handler := s.composeChain(e.rtr, rt, e.slot, prev)
 
-			// Global middleware
-			for i := len(s.middleware) - 1; i >= 0; i-- {
-				mw := s.middleware[i]
+		log.Debug().
+			Str("Name", rt.Name()).
+			Int("RouteID", int(rt.ID())).
+			Int("Priority", e.priority).
+			Bool("Experimental", rt.Experimental()).
+			Msg("registering route")
 
-				if !mw.Status() {
-					continue
-				}
+		if m, ok := rt.(router.Matcher); ok {
+			matchers = append(matchers, engine.Matcher{ID: rt.ID(), Name: rt.Name(), Match: m.Match, Handler: handler})
+			continue
+		}
 
-				if mw.Experimental() && !config.Experimental() {
-					continue
+		low, high := rt.ID(), rt.ID()
+		if r, ok := rt.(router.Ranged); ok {
+			low, high = r.Range()
+		}
+		if low > high {
+			log.Warn().
+				Str("Name", rt.Name()).
+				Int("Low", low).
+				Int("High", high).
+				Msg("empty route range, skipping route")
+			continue
+		}
+		if uint(high)-uint(low) >= router.MaxExpandedRange {
+			matchers = append(matchers, engine.Matcher{
+				ID:      rt.ID(),
+				Name:    rt.Name(),
+				Match:   func(id int) bool { return id >= low && id <= high },
+				Handler: handler,
+			})
+			continue
+		}
+
+		// Stopping at high rather than testing id <= high keeps a range
+		// ending at math.MaxInt from overflowing into an endless loop.
+		for id := low; ; id++ {
+			if prev, ok := registered[id]; ok {
+				msg := "duplicate route ID, replacing lower priority route"
+				if prev.priority == e.priority {
+					msg = "duplicate route ID with equal priority, replacing route loaded earlier"
 				}
 
-				handler = mw.Method()(handler)
+				log.Warn().
+					Int("RouteID", id).
+					Str("Replaced", prev.rt.Name()).
+					Int("ReplacedPriority", prev.priority).
+					Str("Name", rt.Name()).
+					Int("Priority", e.priority).
+					Msg(msg)
 			}
 
-			log.Debug().
-				Str("Name", rt.Name()).
-				Int("RouteID", int(rt.ID())).
-				Bool("Experimental", rt.Experimental()).
-				Bool("Status", rt.Status()).
-				Msg("registering route")
+			registered[id] = e
+			handlers[id] = handler
+			if low != high {
+				keys[id] = rt.ID()
+			} else {
+				delete(keys, id)
+			}
+
+			if id == high {
+				break
+			}
+		}
+	}
 
-			s.engineWrapper.Handlers[rt.ID()] = handler
+	names := make(map[int]string, len(registered))
+	counters := make(map[int]router.ErrorCounter)
+	for id, e := range registered {
+		names[id] = e.rt.Name()
+		if ec, ok := e.rt.(router.ErrorCounter); ok {
+			counters[id] = ec
 		}
 	}
+	s.routeErrors.Store(&counters)
+
+	// Highest priority first, and the last loaded first within a priority,
+	// matching the precedence of exact IDs.
+	slices.Reverse(matchers)
+
+	// Publishing the table also resets the route counters.
+	s.engineWrapper.SetRoutes(engine.RouteTable{Handlers: handlers, Matchers: matchers, Names: names, Keys: keys})
+	return len(entries)
+}
+
+// routePriority returns the registration priority of rt, 0 unless it
+// implements router.Prioritized.
+func routePriority(rt router.Route) int {
+	if p, ok := rt.(router.Prioritized); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// newHandlerTable allocates the handler table of one registration with room
+// for size IDs. It is a variable so tests can observe the size hint.
+var newHandlerTable = func(size int) map[int]handler.HandlerFunc {
+	return make(map[int]handler.HandlerFunc, size)
+}
+
+// chainKey identifies the composed chain of a route: its slot in the routes
+// slice of its router, and the router's middleware slice. Routers built by
+// NewRouter serve the same slices on every call, so their chains are found
+// again by the next registration; a Router building new slices per call
+// simply has its chains recomposed.
+type chainKey struct {
+	route      *router.Route
+	middleware *func(handler.HandlerFunc) handler.HandlerFunc
+	n          int
+}
+
+// chainSignature is the global middleware a chain was composed with.
+// Global middleware is only ever appended, so its count identifies it.
+type chainSignature struct {
+	globals      int
+	experimental bool
 }
 
-// Start launches the server, listening on the configured address and port,
-// and gracefully handles shutdown on system interrupts.
+type composedChain struct {
+	signature chainSignature
+	handler   handler.HandlerFunc
+}
+
+// composeChain wraps the handler of rt, served by rtr, in its middleware:
+// global middleware runs first (outermost), then rtr's, then rt's own.
 //
-// It blocks until the server exits.
+// A chain is composed once and reused by later registrations from prev,
+// the chains of the previous one, while its route, router middleware and
+// global middleware are unchanged; only routes added since, or every route
+// once global middleware is loaded, are recomposed. Global middleware
+// toggled with SetMiddlewareStatus stays in every chain and is checked per
+// request, so a toggle needs no recomposition.
+func (s *Server[T]) composeChain(rtr router.Router, rt router.Route, slot *router.Route, prev map[chainKey]composedChain) handler.HandlerFunc {
+	key := chainKey{route: slot, n: len(rtr.Middleware())}
+	if key.n > 0 {
+		key.middleware = &rtr.Middleware()[0]
+	}
+	signature := chainSignature{globals: len(s.middleware), experimental: config.Experimental()}
+
+	if c, ok := prev[key]; ok && c.signature == signature {
+		s.chains[key] = c
+		return c.handler
+	}
+
+	h := rt.Handler()
+
+	// Route-level middleware (innermost) - wrapped first, so runs last
+	for i := len(rt.Middleware()) - 1; i >= 0; i-- {
+		h = rt.Middleware()[i](h)
+	}
+
+	// Router-level middleware
+	for i := len(rtr.Middleware()) - 1; i >= 0; i-- {
+		h = rtr.Middleware()[i](h)
+	}
+
+	// Global middleware
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		mw := s.middleware[i]
+
+		if mw.Experimental() && !config.Experimental() {
+			continue
+		}
+
+		wrapped := s.engineWrapper.TimedMiddleware(mw.Name(), mw.Method()(h))
+		h = toggleable(s.mwStatus[i], wrapped, h)
+	}
+
+	s.chains[key] = composedChain{signature, h}
+	return h
+}
+
+// toggleable returns a handler that runs wrapped while status is set,
+// and bypasses straight to next otherwise.
+func toggleable(status *atomic.Bool, wrapped, next handler.HandlerFunc) handler.HandlerFunc {
+	return func(conn gnet.Conn, buf []byte) gnet.Action {
+		if status.Load() {
+			return wrapped(conn, buf)
+		}
+		return next(conn, buf)
+	}
+}
+
+// Start launches the server, listening on the configured address and port
+// (plus the configured UDP port, if any), and gracefully handles shutdown
+// on system interrupts (see WithShutdownSignals).
+//
+// It blocks until the server exits. Any failure to start or serve, such as
+// the port already being in use, is fatal; use StartE to handle it instead.
 //
 // Example:
 //
 //	server.Start()
 func (s *Server[T]) Start() {
-	s.registerRoutes()
+	if err := s.StartE(); err != nil {
+		log.Fatal().Err(err).Msg("gnet server failed to start")
+	}
+}
 
-	addr := fmt.Sprintf("%s%s:%d", config.Protocol(), config.Address(), config.Port())
+// StartE is Start, but returns an error instead of exiting the process when
+// the server cannot start or stops serving unexpectedly, e.g. because the
+// listen address cannot be bound.
+//
+// It returns nil once the server has shut down, either on a system interrupt
+// or after Shutdown is called. Errors from a signal-triggered shutdown are
+// logged rather than returned, as in Start.
+//
+// Example:
+//
+//	if err := server.StartE(); err != nil {
+//		return fmt.Errorf("serve: %w", err)
+//	}
+func (s *Server[T]) StartE() error {
+	done, err := s.serve("StartE")
+	if err != nil {
+		return err
+	}
 
+	// Notify with no signals would relay every signal, so an empty set
+	// leaves the channel unregistered instead.
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	if len(s.signals) > 0 {
+		signal.Notify(stop, s.signals...)
+		defer signal.Stop(stop)
+	}
+
+	select {
+	case err := <-done:
+		return s.exited("StartE", err)
+	case sig := <-stop:
+		log.Warn().Str("Signal", sig.String()).Msg("interrupt received, initiating shutdown")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout())*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("error during graceful shutdown")
+	}
 
-	done := make(chan struct{})
+	<-done
+	return nil
+}
+
+// StartAsync launches the server like StartE, but returns as soon as it is
+// accepting connections instead of blocking, for embedding the server next
+// to other services in one process (e.g. in an errgroup). It does not
+// handle signals; the caller decides when to stop.
+//
+// stop shuts the server down with Shutdown and waits for the transport to
+// exit or ctx to be done. If the server fails to start, e.g. because the
+// listen address cannot be bound, StartAsync returns the error instead.
+//
+// Example:
+//
+//	stop, err := server.StartAsync()
+//	if err != nil {
+//		return fmt.Errorf("serve: %w", err)
+//	}
+//	defer stop(context.Background())
+func (s *Server[T]) StartAsync() (stop func(ctx context.Context) error, err error) {
+	done, err := s.serve("StartAsync")
+	if err != nil {
+		return nil, err
+	}
+
+	booted := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	go func() {
-		err := gnet.Run(s.engineWrapper, addr, gnet.WithMulticore(config.EnableMulticore()))
-		if err != nil {
-			log.Fatal().Err(err).Msg("gnet server failed to start")
+		booted <- s.engineWrapper.WaitBooted(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return nil, s.exited("StartAsync", err)
+	case <-booted:
+	}
+
+	return func(ctx context.Context) error {
+		err := s.Shutdown(ctx)
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = errors.Join(err, fmt.Errorf("StartAsync: transport did not stop: %w", ctx.Err()))
+		}
+		return err
+	}, nil
+}
+
+// serve registers the routes, starts the health check and runs the
+// transport in the background. The returned channel receives the result of
+// the transport once it stops. fn names the caller in errors.
+func (s *Server[T]) serve(fn string) (<-chan error, error) {
+	logEffectiveConfig()
+	routes := s.registerRoutes()
+
+	// A route count far beyond what the application defines usually means
+	// the same routers were loaded more than once.
+	if limit := config.MaxRoutes(); limit > 0 && routes > limit {
+		if config.StrictRouting() {
+			return nil, fmt.Errorf("%s: %d routes registered, exceeding maxRoutes %d, refusing to start in strict routing mode", fn, routes, limit)
+		}
+
+		log.Warn().
+			Str("Function", fn).
+			Int("Routes", routes).
+			Int("MaxRoutes", limit).
+			Msg("registered routes exceed maxRoutes; check for routers loaded more than once")
+	}
+
+	if table := s.engineWrapper.Routes(); len(table.Handlers) == 0 && len(table.Matchers) == 0 {
+		if config.StrictRouting() {
+			return nil, fmt.Errorf("%s: no routes registered, refusing to start in strict routing mode", fn)
 		}
-		close(done)
+
+		log.Warn().
+			Str("Function", fn).
+			Msg("no routes registered, every incoming message will be dropped; load routers before calling Start")
+	}
+
+	addrs := []string{listenAddr(config.Protocol(), config.Address(), config.Port())}
+	if port := config.UDPPort(); port > 0 {
+		addrs = append(addrs, listenAddr("udp://", config.Address(), port))
+	}
+
+	if err := s.startHealth(); err != nil {
+		return nil, fmt.Errorf("%s: health check server failed to start: %w", fn, err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.transport.Run(s.engineWrapper, addrs, s.runOptions()...)
 	}()
 
-	<-stop
-	log.Warn().Msg("interrupt received, initiating shutdown")
+	return done, nil
+}
+
+// exited handles the transport stopping with err before a signal arrived:
+// nil after Shutdown, otherwise the transport failed to bind or stopped on
+// its own and the health check is stopped as well.
+func (s *Server[T]) exited(fn string, err error) error {
+	if s.stopping.Load() {
+		return nil
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout())*time.Second)
 	defer cancel()
+	_ = s.stopHealth(ctx)
 
-	if err := s.Shutdown(ctx); err != nil {
-		log.Error().Err(err).Msg("error during graceful shutdown")
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
 	}
+	return fmt.Errorf("%s: transport stopped unexpectedly", fn)
+}
 
-	<-done
+// OnShutdown registers a hook that runs during Shutdown, after the engine
+// has stopped accepting traffic. Hooks run in LIFO order, mirroring defer,
+// so resources are released in the reverse order they were set up.
+//
+// Example:
+//
+//	server.OnShutdown(func(ctx context.Context) error {
+//		return db.Close()
+//	})
+func (s *Server[T]) OnShutdown(hook func(context.Context) error) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// runOptions returns the gnet options passed to the transport: the settings
+// derived from config followed by any added through server options.
+func (s *Server[T]) runOptions() []gnet.Option {
+	opts := []gnet.Option{
+		gnet.WithMulticore(config.EnableMulticore()),
+	}
+	if n := config.NumEventLoops(); n > 0 {
+		opts = append(opts, gnet.WithNumEventLoop(n))
+	}
+	if config.IdleTimeout() > 0 {
+		opts = append(opts, gnet.WithTicker(true))
+	}
+	if !config.EnableTCPNoDelay() {
+		opts = append(opts, gnet.WithTCPNoDelay(gnet.TCPDelay))
+	}
+	if n := config.SocketRecvBuffer(); n > 0 {
+		opts = append(opts, gnet.WithSocketRecvBuffer(n))
+	}
+	if n := config.SocketSendBuffer(); n > 0 {
+		opts = append(opts, gnet.WithSocketSendBuffer(n))
+	}
+	return append(opts, s.gnetOptions...)
+}
+
+// listenAddr composes the gnet listen string, e.g. "tcp://0.0.0.0:30000".
+// IPv6 literals are bracketed ("tcp://[::1]:30000"); an address that is
+// already bracketed is accepted as well.
+func listenAddr(protocol, address string, port int) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	return protocol + net.JoinHostPort(host, strconv.Itoa(port))
 }
 
 // Shutdown gracefully stops the server using the provided context for timeout control.
+// A frame set with WithGoodbyeFrame is delivered to every connection first.
+// Once the engine has stopped, hooks registered with OnShutdown are invoked.
+// Hooks are skipped once the context is done.
 //
-// Returns any error encountered during shutdown.
+// Returns the joined errors of the engine and every hook.
 //
 // Example:
 //
@@ -242,5 +786,489 @@ func (s *Server[T]) Start() {
 //	err := server.Shutdown(ctx)
 func (s *Server[T]) Shutdown(ctx context.Context) error {
 	log.Warn().Str("Function", "Shutdown").Msg("shutting down server")
-	return s.engineWrapper.Engine.Stop(ctx)
+	s.stopping.Store(true)
+
+	var errs []error
+	if s.goodbye != nil {
+		if err := s.engineWrapper.Drain(ctx, s.goodbye); err != nil {
+			errs = append(errs, fmt.Errorf("Shutdown: failed sending goodbye: %w", err))
+		}
+	}
+
+	if err := s.transport.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("Shutdown: failed stopping engine: %w", err))
+	}
+
+	if err := s.stopHealth(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("Shutdown: failed stopping health check: %w", err))
+	}
+
+	if s.engineWrapper.Capture != nil {
+		if err := s.engineWrapper.Capture.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Shutdown: failed closing capture: %w", err))
+		}
+	}
+
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("Shutdown: skipped %d hook(s): %w", i+1, err))
+			break
+		}
+
+		if err := s.shutdownHooks[i](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("Shutdown: hook failed: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithTransport replaces the default gnet transport, e.g. with the
+// in-memory enginetest.Transport for deterministic tests.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithTransport[MyContext](enginetest.NewTransport()))
+func WithTransport[T any](t engine.Transport) Option[T] {
+	return func(s *Server[T]) {
+		s.transport = t
+	}
+}
+
+// WithReusePort enables SO_REUSEPORT on the listener so that several
+// processes can bind the same address at once.
+//
+// This allows restarts without refusing connections: start the new process
+// while the old one is still serving, then send the old process SIGTERM.
+// The kernel spreads new connections across both listeners until the old
+// one shuts down. Shutdown closes every connection of the old process at
+// once, so messages in flight on them are dropped; configure
+// WithGoodbyeFrame so clients are told to reconnect before that happens.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithReusePort[MyContext]())
+func WithReusePort[T any]() Option[T] {
+	return func(s *Server[T]) {
+		s.gnetOptions = append(s.gnetOptions, gnet.WithReusePort(true))
+	}
+}
+
+// WithNumEventLoops sets the number of gnet event loops, overriding the
+// numEventLoops config value. Values <= 0 leave the choice to gnet.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithNumEventLoops[MyContext](16))
+func WithNumEventLoops[T any](n int) Option[T] {
+	return func(s *Server[T]) {
+		if n > 0 {
+			s.gnetOptions = append(s.gnetOptions, gnet.WithNumEventLoop(n))
+		}
+	}
+}
+
+// WithSocketRecvBuffer sets SO_RCVBUF in bytes on connection sockets,
+// overriding the socketRecvBuffer config value. Larger buffers absorb
+// bursts during connection storms at the cost of memory per connection.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithSocketRecvBuffer[MyContext](1<<20))
+func WithSocketRecvBuffer[T any](n int) Option[T] {
+	return func(s *Server[T]) {
+		if n > 0 {
+			s.gnetOptions = append(s.gnetOptions, gnet.WithSocketRecvBuffer(n))
+		}
+	}
+}
+
+// WithSocketSendBuffer sets SO_SNDBUF in bytes on connection sockets,
+// overriding the socketSendBuffer config value.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithSocketSendBuffer[MyContext](1<<20))
+func WithSocketSendBuffer[T any](n int) Option[T] {
+	return func(s *Server[T]) {
+		if n > 0 {
+			s.gnetOptions = append(s.gnetOptions, gnet.WithSocketSendBuffer(n))
+		}
+	}
+}
+
+// WithProtocolMagic requires every connection to open with magic (e.g. a
+// protocol identifier plus version byte) before its first frame. The
+// prefix is consumed once it matches; a mismatch is logged and the
+// connection is closed before any handler runs.
+//
+// UDP datagrams are not checked.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithProtocolMagic[MyContext]([]byte("BMX\x01")))
+func WithProtocolMagic[T any](magic []byte) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.ProtocolMagic = magic
+	}
+}
+
+// WithOnConnectionLimit registers fn to run for each connection refused
+// because MaxConnections has been reached, just before it is closed. fn can
+// log, record a metric or write a one-shot "server full" frame.
+//
+// The refused connection never counts towards the active connections and
+// has no context set.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithOnConnectionLimit[MyContext](func(c gnet.Conn) {
+//			_, _ = c.Write(serverFullPacket)
+//		}))
+func WithOnConnectionLimit[T any](fn func(c gnet.Conn)) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.OnConnectionLimit = fn
+	}
+}
+
+// WithOnIdleWarning registers fn to run once per idle period when a
+// connection has been silent for idleWarningTimeout, ahead of the hard
+// close at idleTimeout. Any traffic from the connection resets the period.
+//
+// fn runs off the connection's event loop, so it must only use the
+// concurrency-safe gnet.Conn methods, such as AsyncWrite.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithOnIdleWarning[MyContext](func(c gnet.Conn) {
+//			_ = c.AsyncWrite(keepalivePacket, nil)
+//		}))
+func WithOnIdleWarning[T any](fn func(c gnet.Conn)) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.OnIdleWarning = fn
+	}
+}
+
+// WithAutoNack replies to messages that have no registered handler with
+// the packet returned by nack, instead of silently dropping them, so
+// clients can fail fast on unsupported messages. nack receives the
+// unmatched message ID so the reply can echo it.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithAutoNack[MyContext](func(msgID int) []byte {
+//			packet, _ := parsing.Frame(nackHeader(msgID), nil)
+//			return packet
+//		}))
+func WithAutoNack[T any](nack func(msgID int) []byte) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.Nack = nack
+	}
+}
+
+// WithWarmup starts the server in a "not ready" state: connections are
+// refused until SetReady(true) is called, e.g. once caches are loaded.
+// If reply is non-nil it is written to each refused connection before it
+// is closed, so clients can back off and retry.
+//
+// While not ready the health check answers 503.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithWarmup[MyContext](retryPacket))
+//	go func() {
+//		loadCaches()
+//		server.SetReady(true)
+//	}()
+//	server.Start()
+func WithWarmup[T any](reply []byte) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.WarmupReply = reply
+		s.engineWrapper.SetReady(false)
+	}
+}
+
+// SetReady switches the server between accepting and refusing new
+// connections. Connections that are already open are not affected.
+// It is safe to call from any goroutine.
+func (s *Server[T]) SetReady(ready bool) {
+	s.engineWrapper.SetReady(ready)
+}
+
+// WithConnIDGenerator replaces the default monotonic connection IDs
+// ("1", "2", ...) with IDs from gen, e.g. engine.UUIDConnID. gen may be
+// called concurrently.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithConnIDGenerator[MyContext](engine.UUIDConnID))
+func WithConnIDGenerator[T any](gen engine.ConnIDFunc) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.ConnIDGenerator = gen
+	}
+}
+
+// ConnID returns the ID assigned to c when it was opened, for correlating
+// logs. Engine log lines carry it in the "conn" field.
+func (s *Server[T]) ConnID(c gnet.Conn) string {
+	return s.engineWrapper.ConnID(c)
+}
+
+// WithCapture records every inbound frame, and every frame the framework
+// sends (Send, BroadcastTo, auto-nacks), to the file at path for later
+// replay with parsing.ReplayCapture. Records carry a timestamp, the
+// connection ID, the direction, the message ID and the raw frame. Bytes
+// handlers write directly on the conn are not captured.
+//
+// The file is appended to, and closed during Shutdown. Failing to open it
+// is fatal. Capturing costs a write per frame, so it is meant for debugging.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithCapture[MyContext]("bmux.capture"))
+func WithCapture[T any](path string) Option[T] {
+	return func(s *Server[T]) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal().Str("Function", "WithCapture").Err(err).Msg("failed to open capture file")
+		}
+		s.engineWrapper.Capture = engine.NewCapture(f)
+	}
+}
+
+// WithGoodbyeFrame sends packet, an already framed message, to every open
+// connection at the start of Shutdown, and closes each connection once the
+// packet has been written, so clients can reconnect elsewhere instead of
+// seeing an abrupt drop. Shutdown waits for the writes until its context
+// is done, then stops the engine as usual.
+//
+// Example:
+//
+//	goodbye, _ := parsing.Frame(goodbyeHeader, nil)
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithGoodbyeFrame[MyContext](goodbye))
+func WithGoodbyeFrame[T any](packet []byte) Option[T] {
+	return func(s *Server[T]) {
+		s.goodbye = packet
+	}
+}
+
+// WithExpectedRoutes sizes the handler table for n message IDs up front,
+// avoiding repeated map growth while registering thousands of routes. n
+// counts IDs, so a ranged route counts once per ID of its range. It is a
+// hint only: more routes still register.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithExpectedRoutes[MyContext](4096))
+func WithExpectedRoutes[T any](n int) Option[T] {
+	return func(s *Server[T]) {
+		s.expectedRoutes = n
+	}
+}
+
+// WithShutdownSignals sets the signals that trigger a graceful shutdown
+// in Start, replacing the default os.Interrupt and SIGTERM, e.g. for a
+// supervisor that stops services with SIGQUIT. With no signals, Start does
+// not handle signals at all and the server only stops on Shutdown.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithShutdownSignals[MyContext](syscall.SIGQUIT, syscall.SIGTERM))
+func WithShutdownSignals[T any](sigs ...os.Signal) Option[T] {
+	return func(s *Server[T]) {
+		s.signals = sigs
+	}
+}
+
+// WithClock makes the engine's time-based features (idle timeouts, rate
+// limits, handler queue waits, write coalescing, capture timestamps, latency
+// stats) use c instead of real time, so tests can advance time with a
+// clock.Fake. Middleware and route options that keep time, such as
+// middleware.NewDedupMiddleware and router.WithCircuitBreaker, take their
+// own clock.
+//
+// Example:
+//
+//	clk := clock.NewFake(time.Now())
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithClock[MyContext](clk))
+func WithClock[T any](c clock.Clock) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.Clock = c
+	}
+}
+
+// WithFramer replaces the default length-prefix framing with f, e.g. for
+// newline-delimited or other delimiter based protocols. extractLength and
+// the configured headSize are then unused; f (and its Split method, if it
+// implements engine.FrameSplitter) decides what ExtractMsgID and handlers see.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithFramer[MyContext](parsing.LineFramer{MaxLine: 4096}))
+func WithFramer[T any](f engine.Framer) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.Framer = f
+	}
+}
+
+// WithReplayProtection drops frames that repeat a sequence number already
+// seen on their connection, for protocols where replaying a captured frame
+// must have no effect. extract reads the sequence number from a frame;
+// frames it reports none for are not checked.
+//
+// Frames may arrive out of order by up to window sequence numbers (at most
+// 64); older ones are dropped as well. UDP traffic has no connection state
+// and is not checked.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil,
+//		bmux.WithReplayProtection[MyContext](func(c gnet.Conn, head, body []byte) (uint64, bool) {
+//			return binary.BigEndian.Uint64(head[4:12]), true
+//		}, 32))
+func WithReplayProtection[T any](extract engine.ExtractSequenceFunc[T], window int) Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.ExtractSequence = extract
+		s.engineWrapper.ReplayWindow = window
+	}
+}
+
+// WithLatencyStats enables handler latency recording per route, exposed
+// through Server.LatencyStats, and per global middleware, exposed through
+// Server.MiddlewareLatencyStats.
+//
+// When disabled (the default) the engine skips timing entirely.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithLatencyStats[MyContext]())
+func WithLatencyStats[T any]() Option[T] {
+	return func(s *Server[T]) {
+		s.engineWrapper.EnableLatencyStats()
+	}
+}
+
+// Engine registers the routes loaded so far, as Start does, and returns
+// the event handler the server runs. It lets the server be driven without
+// a listener, e.g. by bmuxtest.RoundTrip; Start registers the routes again.
+func (s *Server[T]) Engine() *engine.EngineWrapper[T] {
+	s.registerRoutes()
+	return s.engineWrapper
+}
+
+// SetLogOutput rebuilds the log sink of the bmux, engine and router
+// loggers with the given logFormat and logOutput values (see the config
+// keys) and swaps it in atomically, e.g. after a config reload. Log lines
+// in flight finish on the old sink, and a previous log file is closed.
+//
+// Example:
+//
+//	err := server.SetLogOutput("json", "/var/log/bmux.log")
+func (s *Server[T]) SetLogOutput(format, output string) error {
+	w, err := newLogWriter(format, output)
+	if err != nil {
+		return fmt.Errorf("SetLogOutput: %w", err)
+	}
+	setLogWriter(w)
+	return nil
+}
+
+// RouteErrors returns the number of errors returned so far by each
+// registered route built with router.NewErrorRoute, keyed by message ID.
+func (s *Server[T]) RouteErrors() map[int]uint64 {
+	counters := s.routeErrors.Load()
+	if counters == nil {
+		return nil
+	}
+
+	out := make(map[int]uint64, len(*counters))
+	for id, ec := range *counters {
+		out[id] = ec.Errors()
+	}
+	return out
+}
+
+// LatencyStats returns the handler execution time per message ID (count,
+// p50 and p99), or nil unless the server was built WithLatencyStats.
+//
+// Only the handler invocation is timed, i.e. the full composed middleware
+// chain for the route. A range or matcher route is reported once, under
+// its ID(), however many message IDs it serves.
+func (s *Server[T]) LatencyStats() map[int]engine.LatencyStats {
+	return s.engineWrapper.LatencyStats()
+}
+
+// MiddlewareLatencyStats returns the execution time of each global
+// middleware by name, including everything it wraps, or nil unless the
+// server was built WithLatencyStats. Disabled middleware is not timed.
+func (s *Server[T]) MiddlewareLatencyStats() map[string]engine.LatencyStats {
+	return s.engineWrapper.MiddlewareLatencyStats()
+}
+
+// TopRoutes returns the n most invoked routes since Start with their
+// message counts, busiest first. A negative n returns every route.
+//
+// Example:
+//
+//	for _, rc := range server.TopRoutes(5) {
+//		fmt.Printf("%s %#x: %d\n", rc.Name, rc.ID, rc.Count)
+//	}
+func (s *Server[T]) TopRoutes(n int) []engine.RouteCount {
+	return s.engineWrapper.TopRoutes(n)
+}
+
+// SetTags replaces the tags attached to a connection. Tags group
+// connections so they can be addressed together with BroadcastTo.
+//
+// It is safe to call from handlers.
+//
+// Example:
+//
+//	func HandleJoin(s *bmux.Server[Context]) handler.HandlerFunc {
+//		return func(conn gnet.Conn, body []byte) gnet.Action {
+//			s.SetTags(conn, "room:42")
+//			return gnet.None
+//		}
+//	}
+func (s *Server[T]) SetTags(c gnet.Conn, tags ...string) {
+	s.engineWrapper.SetTags(c, tags...)
+}
+
+// Send asynchronously writes an already framed packet to c. It is safe to
+// call from any goroutine.
+//
+// When writeCoalesceWindow is configured, packets sent to the same
+// connection are buffered for up to that window (or until
+// writeCoalesceBytes accumulate) and flushed in one write, trading a little
+// latency for fewer syscalls. Bytes written directly on the conn are not
+// coalesced and may overtake buffered packets.
+//
+// Example:
+//
+//	err := server.Send(conn, packet)
+func (s *Server[T]) Send(c gnet.Conn, packet []byte) error {
+	return s.engineWrapper.AsyncWrite(c, packet)
+}
+
+// BroadcastTo asynchronously writes an already framed packet to every
+// open connection carrying tag.
+//
+// Returns the joined errors of any writes that could not be queued.
+//
+// Example:
+//
+//	err := server.BroadcastTo("room:42", packet)
+func (s *Server[T]) BroadcastTo(tag string, packet []byte) error {
+	var errs []error
+	for _, c := range s.engineWrapper.Tagged(tag) {
+		if err := s.engineWrapper.AsyncWrite(c, packet); err != nil {
+			errs = append(errs, fmt.Errorf("BroadcastTo: failed queueing write: %w", err))
+		}
+	}
+	return errors.Join(errs...)
 }
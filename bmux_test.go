@@ -0,0 +1,209 @@
+package bmux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/rs/zerolog"
+)
+
+type testContext struct{}
+
+func newContext() *testContext { return &testContext{} }
+
+// extractLength reads a 1 byte head length and a 2 byte body length.
+func extractLength(_ gnet.Conn, buf []byte) (headLen, totalLen int) {
+	hd := int(buf[0])
+	return hd, hd + int(binary.LittleEndian.Uint16(buf[1:3]))
+}
+
+// extractMsgID reads the message ID from the first head byte.
+func extractMsgID(_ gnet.Conn, head, _ []byte) int {
+	if len(head) == 0 {
+		return -1
+	}
+	return int(head[0])
+}
+
+// frame builds a frame with a single byte head holding id.
+func frame(id byte, body string) []byte {
+	buf := []byte{1, 0, 0, id}
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(len(body)))
+	return append(buf, body...)
+}
+
+// newServer loads cfg as the process configuration, which New keeps once
+// loaded, and returns a server on an in-memory transport.
+func newServer(t *testing.T, cfg config.Config, opts ...Option[testContext]) (*Server[testContext], *enginetest.Transport) {
+	t.Helper()
+
+	if err := config.LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &cfg); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	tr := enginetest.NewTransport()
+	opts = append([]Option[testContext]{WithTransport[testContext](tr)}, opts...)
+	return New(newContext, extractLength, extractMsgID, nil, opts...), tr
+}
+
+// recordingTransport is an in-memory transport that records the listen
+// addresses it was run on.
+type recordingTransport struct {
+	*enginetest.Transport
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (t *recordingTransport) Run(eventHandler gnet.EventHandler, addrs []string, opts ...gnet.Option) error {
+	t.mu.Lock()
+	t.addrs = addrs
+	t.mu.Unlock()
+	return t.Transport.Run(eventHandler, addrs, opts...)
+}
+
+func (t *recordingTransport) Addrs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.addrs
+}
+
+// logBuffer is a log sink that tests can read while the server writes.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the bmux, engine and router loggers to a JSON
+// buffer until the test ends. Call it after newServer, which installs the
+// configured sink.
+func captureLog(t *testing.T) *logBuffer {
+	var b logBuffer
+	setLogWriter(&b)
+	t.Cleanup(func() { setLogWriter(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "2006-01-02T15:04:05"}) })
+	return &b
+}
+
+func ok() handler.HandlerFunc {
+	return func(gnet.Conn, []byte) gnet.Action { return gnet.None }
+}
+
+func singleRouter(routes ...router.Route) []router.Router {
+	return []router.Router{router.NewRouter(true, routes, nil)}
+}
+
+func TestRunOptionsKeepTCPNoDelayForOverrides(t *testing.T) {
+	s, _ := newServer(t, config.Config{Port: 40000, HeadSize: 3})
+
+	var opts gnet.Options
+	for _, opt := range s.runOptions() {
+		opt(&opts)
+	}
+
+	if opts.TCPNoDelay != gnet.TCPNoDelay {
+		t.Fatalf("TCPNoDelay = %v, want gnet.TCPNoDelay", opts.TCPNoDelay)
+	}
+}
+
+func TestRunOptionsDelayWhenTCPNoDelayDisabled(t *testing.T) {
+	disabled := false
+	s, _ := newServer(t, config.Config{Port: 40000, HeadSize: 3, EnableTCPNoDelay: &disabled})
+
+	var opts gnet.Options
+	for _, opt := range s.runOptions() {
+		opt(&opts)
+	}
+
+	if opts.TCPNoDelay != gnet.TCPDelay {
+		t.Fatalf("TCPNoDelay = %v, want gnet.TCPDelay", opts.TCPNoDelay)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		protocol, address string
+		port              int
+		want              string
+	}{
+		{"tcp://", "0.0.0.0", 30000, "tcp://0.0.0.0:30000"},
+		{"udp://", "127.0.0.1", 53, "udp://127.0.0.1:53"},
+		{"tcp://", "localhost", 8080, "tcp://localhost:8080"},
+		{"tcp://", "", 30000, "tcp://:30000"},
+		{"tcp://", "::1", 30000, "tcp://[::1]:30000"},
+		{"tcp://", "[::1]", 30000, "tcp://[::1]:30000"},
+		{"tcp6://", "::", 0, "tcp6://[::]:0"},
+		{"unix://", "fe80::1%eth0", 9, "unix://[fe80::1%eth0]:9"},
+	} {
+		if got := listenAddr(tc.protocol, tc.address, tc.port); got != tc.want {
+			t.Errorf("listenAddr(%q, %q, %d) = %q, want %q", tc.protocol, tc.address, tc.port, got, tc.want)
+		}
+	}
+}
+
+// applied returns the gnet options the server would run with.
+func applied(s *Server[testContext]) gnet.Options {
+	var opts gnet.Options
+	for _, opt := range s.runOptions() {
+		opt(&opts)
+	}
+	return opts
+}
+
+func TestRunOptionsNumEventLoops(t *testing.T) {
+	cfg := testConfig()
+	s, _ := newServer(t, cfg)
+	if n := applied(s).NumEventLoop; n != 0 {
+		t.Errorf("NumEventLoop = %d with numEventLoops unset, want 0", n)
+	}
+
+	cfg.NumEventLoops = 4
+	s, _ = newServer(t, cfg)
+	if n := applied(s).NumEventLoop; n != 4 {
+		t.Errorf("NumEventLoop = %d from config, want 4", n)
+	}
+
+	s, _ = newServer(t, cfg, WithNumEventLoops[testContext](8))
+	if n := applied(s).NumEventLoop; n != 8 {
+		t.Errorf("NumEventLoop = %d with WithNumEventLoops(8), want the option to win", n)
+	}
+}
+
+func TestRunOptionsSocketBuffers(t *testing.T) {
+	cfg := testConfig()
+	s, _ := newServer(t, cfg)
+	if opts := applied(s); opts.SocketRecvBuffer != 0 || opts.SocketSendBuffer != 0 {
+		t.Errorf("socket buffers = %d/%d with the config unset, want the OS defaults (0/0)", opts.SocketRecvBuffer, opts.SocketSendBuffer)
+	}
+
+	cfg.SocketRecvBuffer, cfg.SocketSendBuffer = 1<<16, 1<<17
+	s, _ = newServer(t, cfg)
+	if opts := applied(s); opts.SocketRecvBuffer != 1<<16 || opts.SocketSendBuffer != 1<<17 {
+		t.Errorf("socket buffers = %d/%d from config, want %d/%d", opts.SocketRecvBuffer, opts.SocketSendBuffer, 1<<16, 1<<17)
+	}
+
+	s, _ = newServer(t, cfg, WithSocketRecvBuffer[testContext](1<<20), WithSocketSendBuffer[testContext](1<<21))
+	if opts := applied(s); opts.SocketRecvBuffer != 1<<20 || opts.SocketSendBuffer != 1<<21 {
+		t.Errorf("socket buffers = %d/%d with options, want the options to win", opts.SocketRecvBuffer, opts.SocketSendBuffer)
+	}
+}
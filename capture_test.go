@@ -0,0 +1,66 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/parsing"
+	"github.com/etwodev/bmux/pkg/router"
+)
+
+func TestCaptureReplaysTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bmux.capture")
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	s, tr := newServer(t, testConfig(), WithCapture[testContext](path), WithClock[testContext](clk))
+	s.LoadRouter(singleRouter(router.NewRoute("Ping", 1, true, false, ok(), nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+
+	c := tr.Dial()
+	id := s.ConnID(c)
+	tr.Send(c, frame(1, "ping"))
+	clk.Advance(time.Second)
+	if err := s.Send(c, frame(2, "pong")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []engine.CaptureRecord
+	if err := parsing.ReplayCapture(f, func(rec engine.CaptureRecord) error {
+		got = append(got, rec)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayCapture: %v", err)
+	}
+
+	want := []engine.CaptureRecord{
+		{Time: time.Unix(1700000000, 0), ConnID: id, Direction: engine.Inbound, MsgID: 1, Frame: frame(1, "ping")},
+		{Time: time.Unix(1700000001, 0), ConnID: id, Direction: engine.Outbound, MsgID: -1, Frame: frame(2, "pong")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("replayed %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) || g.ConnID != w.ConnID || g.Direction != w.Direction || g.MsgID != w.MsgID || !bytes.Equal(g.Frame, w.Frame) {
+			t.Errorf("record %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package bmux
+
+import (
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/etwodev/bmux/pkg/middleware"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// composeCounter is a middleware counting how often it wraps a handler
+// and how often a wrapped handler runs.
+type composeCounter struct{ composed, ran int }
+
+func (m *composeCounter) wrap(next handler.HandlerFunc) handler.HandlerFunc {
+	m.composed++
+	return func(c gnet.Conn, body []byte) gnet.Action {
+		m.ran++
+		return next(c, body)
+	}
+}
+
+func TestRouterMiddlewareWrapsEachRouteOnce(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	var mw composeCounter
+	s.LoadRouter([]router.Router{router.NewRouter(true, []router.Route{
+		router.NewRoute("One", 1, true, false, ok(), nil),
+		router.NewRoute("Two", 2, true, false, ok(), nil),
+	}, []func(handler.HandlerFunc) handler.HandlerFunc{mw.wrap})})
+
+	e, c := s.Engine(), enginetest.NewConn()
+	enginetest.Traffic(e, c, frame(1, ""))
+	enginetest.Traffic(e, c, frame(2, ""))
+
+	if mw.composed != 2 || mw.ran != 2 {
+		t.Fatalf("router middleware composed %d and ran %d times, want 2 and 2", mw.composed, mw.ran)
+	}
+}
+
+func TestChainsRecomposedOnlyForImpactedRoutes(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	var first, second composeCounter
+	s.LoadRouter(singleRouter(router.NewRoute("One", 1, true, false, ok(),
+		[]func(handler.HandlerFunc) handler.HandlerFunc{first.wrap})))
+
+	s.Engine()
+	s.Engine()
+	if first.composed != 1 {
+		t.Fatalf("unchanged route composed %d times over two registrations, want 1", first.composed)
+	}
+
+	s.LoadRouter(singleRouter(router.NewRoute("Two", 2, true, false, ok(),
+		[]func(handler.HandlerFunc) handler.HandlerFunc{second.wrap})))
+	s.Engine()
+	if first.composed != 1 || second.composed != 1 {
+		t.Fatalf("after loading a router: composed %d and %d times, want 1 and 1", first.composed, second.composed)
+	}
+
+	var global composeCounter
+	s.LoadMiddleware([]middleware.Middleware{middleware.NewMiddleware(global.wrap, "global", true, false)})
+	e := s.Engine()
+	if first.composed != 2 || second.composed != 2 || global.composed != 2 {
+		t.Fatalf("after loading global middleware: composed %d, %d and %d times, want 2 each",
+			first.composed, second.composed, global.composed)
+	}
+
+	c := enginetest.NewConn()
+	enginetest.Traffic(e, c, frame(1, ""))
+	enginetest.Traffic(e, c, frame(2, ""))
+	if first.ran != 1 || second.ran != 1 || global.ran != 2 {
+		t.Fatalf("middleware ran %d, %d and %d times, want 1, 1 and 2", first.ran, second.ran, global.ran)
+	}
+}
+
+func TestLoadRouterSkipsOnlyTheSameRouter(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	var traced, audited composeCounter
+	routes := []router.Route{router.NewRoute("One", 1, true, false, ok(), nil)}
+	tracedRouter := router.NewRouter(true, routes, []func(handler.HandlerFunc) handler.HandlerFunc{traced.wrap})
+
+	s.LoadRouter([]router.Router{
+		tracedRouter,
+		tracedRouter,
+		router.NewRouter(true, routes, []func(handler.HandlerFunc) handler.HandlerFunc{audited.wrap}),
+	})
+
+	if n := len(s.routers); n != 2 {
+		t.Fatalf("loaded %d routers, want the duplicate skipped and the other middleware kept", n)
+	}
+}
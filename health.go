@@ -0,0 +1,88 @@
+package bmux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// healthStatus is the JSON body served by the health check endpoint.
+type healthStatus struct {
+	Status            string `json:"status"`
+	ActiveConnections int64  `json:"activeConnections"`
+}
+
+// WithHealthCheck serves an HTTP health endpoint on addr, separate from the
+// gnet listener. It answers 200 with a JSON stats snapshot while the server
+// is accepting connections, and 503 before the engine has booted, while it
+// is warming up (see WithWarmup) and once shutdown has begun.
+//
+// The endpoint starts with Start and stops during Shutdown.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithHealthCheck[MyContext](":8081"))
+func WithHealthCheck[T any](addr string) Option[T] {
+	return func(s *Server[T]) {
+		s.healthAddr = addr
+	}
+}
+
+// healthy reports whether the server is accepting connections.
+func (s *Server[T]) healthy() bool {
+	return s.engineWrapper.Booted() && s.engineWrapper.Ready() && !s.stopping.Load()
+}
+
+func (s *Server[T]) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	status := healthStatus{
+		Status:            "ok",
+		ActiveConnections: atomic.LoadInt64(&s.engineWrapper.ActiveConnections),
+	}
+
+	code := http.StatusOK
+	if !s.healthy() {
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Debug().Err(err).Msg("failed to write health response")
+	}
+}
+
+// startHealth binds the health endpoint, if one is configured.
+func (s *Server[T]) startHealth() error {
+	if s.healthAddr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.healthAddr)
+	if err != nil {
+		return fmt.Errorf("startHealth: failed to listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveHealth)
+	s.health = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.health.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("health check server failed")
+		}
+	}()
+	return nil
+}
+
+// stopHealth shuts the health endpoint down, if it was started.
+func (s *Server[T]) stopHealth(ctx context.Context) error {
+	if s.health == nil {
+		return nil
+	}
+	return s.health.Shutdown(ctx)
+}
@@ -0,0 +1,85 @@
+package bmux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// getHealth fetches the health endpoint at addr.
+func getHealth(t *testing.T, addr string) (int, healthStatus) {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET health: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status healthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding health response: %v", err)
+	}
+	return resp.StatusCode, status
+}
+
+func TestHealthCheckReportsReadiness(t *testing.T) {
+	addr := "127.0.0.1:" + strconv.Itoa(freePort(t))
+	s, tr := newServer(t, testConfig(), WithHealthCheck[testContext](addr))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	stopped := false
+	defer func() {
+		if !stopped {
+			_ = stop(context.Background())
+		}
+	}()
+
+	tr.Dial()
+	tr.Dial()
+	code, status := getHealth(t, addr)
+	if code != http.StatusOK || status.Status != "ok" || status.ActiveConnections != 2 {
+		t.Fatalf("serving: %d %+v, want 200 ok with 2 connections", code, status)
+	}
+
+	s.SetReady(false)
+	if code, status := getHealth(t, addr); code != http.StatusServiceUnavailable || status.Status != "unavailable" {
+		t.Fatalf("not ready: %d %+v, want 503 unavailable", code, status)
+	}
+	s.SetReady(true)
+
+	stopped = true
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if _, err := http.Get("http://" + addr + "/"); err == nil {
+		t.Fatal("health endpoint still answering after Shutdown")
+	}
+}
+
+func TestHealthCheckUnavailableWhileDraining(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+
+	if s.healthy() {
+		t.Fatal("healthy before the engine booted")
+	}
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	if !s.healthy() {
+		t.Fatal("unhealthy while serving")
+	}
+	s.stopping.Store(true)
+	if s.healthy() {
+		t.Fatal("healthy once shutdown began")
+	}
+}
@@ -0,0 +1,36 @@
+// Package logwriter provides the swappable sink behind the package loggers
+// of bmux, engine and router.
+package logwriter
+
+import (
+	"io"
+	"sync"
+)
+
+// Swap is an io.Writer whose destination can be replaced while other
+// goroutines are writing through it. Each Write goes entirely to either the
+// old or the new destination.
+type Swap struct {
+	mu sync.RWMutex
+	w  io.Writer
+}
+
+// New returns a Swap writing to w.
+func New(w io.Writer) *Swap {
+	return &Swap{w: w}
+}
+
+func (s *Swap) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.w.Write(p)
+}
+
+// Store makes w the destination of every subsequent Write. It returns once
+// the Writes in flight to the previous destination have finished, so that
+// destination may then be closed.
+func (s *Swap) Store(w io.Writer) {
+	s.mu.Lock()
+	s.w = w
+	s.mu.Unlock()
+}
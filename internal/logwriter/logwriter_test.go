@@ -0,0 +1,48 @@
+package logwriter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// blockingWriter holds each Write until release is closed.
+type blockingWriter struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	close(b.entered)
+	<-b.release
+	return len(p), nil
+}
+
+func TestStoreWaitsForWritesInFlight(t *testing.T) {
+	old := &blockingWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	s := New(old)
+
+	go func() { _, _ = s.Write([]byte("line")) }()
+	<-old.entered
+
+	var next bytes.Buffer
+	stored := make(chan struct{})
+	go func() {
+		s.Store(&next)
+		close(stored)
+	}()
+
+	select {
+	case <-stored:
+		t.Fatal("Store returned while a Write to the old destination was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(old.release)
+	<-stored
+
+	_, _ = s.Write([]byte("after"))
+	if got := next.String(); got != "after" {
+		t.Fatalf("new destination got %q, want %q", got, "after")
+	}
+}
@@ -0,0 +1,98 @@
+package bmux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/rs/zerolog"
+)
+
+// newLogWriter builds the log sink described by the logFormat and
+// logOutput config values.
+//
+// format is "console" (human readable, the default) or "json". output is
+// "stdout" (the default), "stderr", or a file path opened for appending.
+func newLogWriter(format, output string) (io.Writer, error) {
+	var out io.Writer
+	switch output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("newLogWriter: failed opening log file: %w", err)
+		}
+		out = f
+	}
+
+	switch format {
+	case "", "console":
+		return zerolog.ConsoleWriter{Out: out, TimeFormat: "2006-01-02T15:04:05"}, nil
+	case "json":
+		return out, nil
+	default:
+		return nil, fmt.Errorf("newLogWriter: unknown log format %q", format)
+	}
+}
+
+// logFile is the log file opened by the current sink, if any.
+var logFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// setLogWriter points the bmux, engine and router loggers at w, and closes
+// the log file of the sink it replaces once the lines being written to it
+// have finished.
+func setLogWriter(w io.Writer) {
+	logOut.Store(w)
+	engine.SetLogWriter(w)
+	router.SetLogWriter(w)
+
+	var f *os.File
+	switch w := w.(type) {
+	case *os.File:
+		f = w
+	case zerolog.ConsoleWriter:
+		f, _ = w.Out.(*os.File)
+	}
+	if f == os.Stdout || f == os.Stderr {
+		f = nil
+	}
+
+	logFile.mu.Lock()
+	prev := logFile.f
+	logFile.f = f
+	logFile.mu.Unlock()
+
+	if prev != nil && prev != f {
+		_ = prev.Close()
+	}
+}
+
+// logEffectiveConfig logs the fully resolved configuration at debug level,
+// one field per config key, so the values actually in effect (including
+// backfilled defaults) can be checked at startup.
+func logEffectiveConfig() {
+	raw, err := json.Marshal(config.Snapshot())
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to marshal effective configuration")
+		return
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		log.Debug().Err(err).Msg("failed to unmarshal effective configuration")
+		return
+	}
+
+	log.Debug().Fields(fields).Msg("effective configuration")
+}
@@ -0,0 +1,154 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/rs/zerolog"
+)
+
+func TestSetLogOutputKeepsLinesInFlight(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}
+	t.Cleanup(func() { _ = s.SetLogOutput("console", "stderr") })
+
+	if err := s.SetLogOutput("json", paths[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, lines = 4, 500
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range lines {
+				log.Error().Int("Line", i).Msg("swap test")
+			}
+		}()
+	}
+	for i := range 100 {
+		if err := s.SetLogOutput("json", paths[i%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	got := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += bytes.Count(data, []byte("swap test"))
+	}
+	if got != writers*lines {
+		t.Fatalf("log files hold %d lines, want %d", got, writers*lines)
+	}
+}
+
+func TestNewLogWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bmux.log")
+	for _, tc := range []struct {
+		format, output string
+		console        bool
+		out            *os.File
+	}{
+		{"", "", true, os.Stdout},
+		{"console", "stdout", true, os.Stdout},
+		{"console", "stderr", true, os.Stderr},
+		{"json", "stdout", false, os.Stdout},
+		{"json", "stderr", false, os.Stderr},
+		{"json", path, false, nil},
+		{"console", path, true, nil},
+	} {
+		w, err := newLogWriter(tc.format, tc.output)
+		if err != nil {
+			t.Fatalf("newLogWriter(%q, %q): %v", tc.format, tc.output, err)
+		}
+
+		var f *os.File
+		if cw, ok := w.(zerolog.ConsoleWriter); ok {
+			f, _ = cw.Out.(*os.File)
+		} else if tc.console {
+			t.Errorf("newLogWriter(%q, %q) = %T, want a console writer", tc.format, tc.output, w)
+			continue
+		} else {
+			f, _ = w.(*os.File)
+		}
+
+		switch {
+		case f == nil:
+			t.Errorf("newLogWriter(%q, %q) does not write to a file", tc.format, tc.output)
+		case tc.out != nil && f != tc.out:
+			t.Errorf("newLogWriter(%q, %q) writes to %s, want %s", tc.format, tc.output, f.Name(), tc.out.Name())
+		case tc.out == nil:
+			if f.Name() != path {
+				t.Errorf("newLogWriter(%q, %q) writes to %s, want %s", tc.format, tc.output, f.Name(), path)
+			}
+			_ = f.Close()
+		}
+	}
+}
+
+func TestNewLogWriterErrors(t *testing.T) {
+	if _, err := newLogWriter("xml", "stdout"); err == nil {
+		t.Fatal("newLogWriter accepted an unknown format")
+	}
+	if _, err := newLogWriter("json", filepath.Join(t.TempDir(), "missing", "bmux.log")); err == nil {
+		t.Fatal("newLogWriter accepted a log file in a missing directory")
+	}
+}
+
+func TestEffectiveConfigLogged(t *testing.T) {
+	cfg := testConfig()
+	cfg.LogLevel = "debug"
+	cfg.Port = 40123
+	cfg.MaxFramesPerSecond = 50
+	s, _ := newServer(t, cfg)
+	logs := captureLog(t)
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	var fields map[string]any
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "effective configuration") {
+			if err := json.Unmarshal([]byte(line), &fields); err != nil {
+				t.Fatalf("effective configuration line is not JSON: %v", err)
+			}
+			break
+		}
+	}
+	if fields == nil {
+		t.Fatalf("effective configuration not logged:\n%s", logs)
+	}
+
+	if fields["port"] != float64(40123) || fields["maxFramesPerSecond"] != float64(50) {
+		t.Errorf("logged port %v, maxFramesPerSecond %v, want 40123, 50", fields["port"], fields["maxFramesPerSecond"])
+	}
+	if fields["protocol"] != "tcp://" {
+		t.Errorf("logged protocol %v, want the default tcp://", fields["protocol"])
+	}
+
+	// Every config field is logged, including ones left at their zero value.
+	raw, _ := json.Marshal(config.Snapshot())
+	var all map[string]any
+	_ = json.Unmarshal(raw, &all)
+	for key := range all {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("effective configuration misses %q", key)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package bmux
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/etwodev/bmux/pkg/middleware"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// counting returns a middleware that counts the messages it wraps.
+func counting(name string, status bool, count *int) middleware.Middleware {
+	return middleware.NewMiddleware(func(next handler.HandlerFunc) handler.HandlerFunc {
+		return func(c gnet.Conn, body []byte) gnet.Action {
+			*count++
+			return next(c, body)
+		}
+	}, name, status, false)
+}
+
+func TestSetMiddlewareStatusToggles(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	var traced int
+	s.LoadMiddleware([]middleware.Middleware{counting("trace", true, &traced)})
+	s.LoadRouter(singleRouter(router.NewRoute("Exact", 1, true, false, ok(), nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+	c := tr.Dial()
+
+	tr.Send(c, frame(1, ""))
+	if err := s.SetMiddlewareStatus("trace", false); err != nil {
+		t.Fatalf("SetMiddlewareStatus: %v", err)
+	}
+	tr.Send(c, frame(1, ""))
+	if traced != 1 {
+		t.Fatalf("disabled middleware ran: %d calls, want 1", traced)
+	}
+
+	if err := s.SetMiddlewareStatus("trace", true); err != nil {
+		t.Fatalf("SetMiddlewareStatus: %v", err)
+	}
+	tr.Send(c, frame(1, ""))
+	if traced != 2 {
+		t.Fatalf("re-enabled middleware ran %d times, want 2", traced)
+	}
+
+	if err := s.SetMiddlewareStatus("missing", true); err == nil {
+		t.Fatal("SetMiddlewareStatus succeeded for an unknown name")
+	}
+}
+
+func TestMiddlewareSharingANameKeepsOwnStatus(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	var enabled, disabled int
+	s.LoadMiddleware([]middleware.Middleware{
+		counting("", true, &enabled),
+		counting("", false, &disabled),
+	})
+	s.LoadRouter(singleRouter(router.NewRoute("Exact", 1, true, false, ok(), nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	tr.Send(tr.Dial(), frame(1, ""))
+	if enabled != 1 || disabled != 0 {
+		t.Fatalf("enabled ran %d times, disabled %d times, want 1 and 0", enabled, disabled)
+	}
+}
+
+func TestMiddlewareLatencyStats(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	s, tr := newServer(t, testConfig(), WithClock[testContext](clk), WithLatencyStats[testContext]())
+	s.LoadMiddleware([]middleware.Middleware{middleware.NewMiddleware(func(next handler.HandlerFunc) handler.HandlerFunc {
+		return func(c gnet.Conn, body []byte) gnet.Action {
+			clk.Advance(5 * time.Millisecond)
+			return next(c, body)
+		}
+	}, "auth", true, false)})
+	s.LoadRouter(singleRouter(router.NewRoute("Slow", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		clk.Advance(20 * time.Millisecond)
+		return gnet.None
+	}, nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	c := tr.Dial()
+	tr.Send(c, frame(1, ""))
+	tr.Send(c, frame(1, ""))
+
+	if got := s.MiddlewareLatencyStats()["auth"]; got.Count != 2 || got.P50 != 25*time.Millisecond {
+		t.Errorf("auth middleware stats = %+v, want 2 messages at 25ms", got)
+	}
+	if got := s.LatencyStats()[1]; got.Count != 2 || got.P99 != 25*time.Millisecond {
+		t.Errorf("route stats = %+v, want 2 messages at 25ms", got)
+	}
+}
+
+// tracer returns middleware that appends name to order before calling next.
+func tracer(name string, order *[]string) func(handler.HandlerFunc) handler.HandlerFunc {
+	return func(next handler.HandlerFunc) handler.HandlerFunc {
+		return func(c gnet.Conn, body []byte) gnet.Action {
+			*order = append(*order, name)
+			return next(c, body)
+		}
+	}
+}
+
+func TestGroupMiddlewareOrder(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	var order []string
+	s.LoadMiddleware([]middleware.Middleware{
+		middleware.NewMiddleware(tracer("global", &order), "global", true, false),
+	})
+
+	admin := router.Group(true, tracer("auth", &order), tracer("audit", &order))
+	admin.Handle(0xF1, "Drain", func(gnet.Conn, []byte) gnet.Action {
+		order = append(order, "handler")
+		return gnet.None
+	})
+	admin.Handle(0xF2, "Stats", ok())
+	disabled := router.Group(false, tracer("disabled", &order))
+	disabled.Handle(0xF3, "Hidden", ok())
+	s.LoadRouter([]router.Router{admin.Router(), disabled.Router()})
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+	c := tr.Dial()
+
+	tr.Send(c, frame(0xF1, ""))
+	if want := []string{"global", "auth", "audit", "handler"}; !slices.Equal(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+
+	order = nil
+	tr.Send(c, frame(0xF2, ""))
+	tr.Send(c, frame(0xF3, ""))
+	if want := []string{"global", "auth", "audit"}; !slices.Equal(order, want) {
+		t.Fatalf("ran %v for a sibling route and a disabled group, want %v", order, want)
+	}
+}
@@ -0,0 +1,37 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/router"
+)
+
+func TestAutoNackEchoesUnmatchedID(t *testing.T) {
+	nack := func(msgID int) []byte { return frame(0xFF, string(rune(msgID))) }
+	s, tr := newServer(t, testConfig(), WithAutoNack[testContext](nack))
+	s.LoadRouter(singleRouter(router.NewRoute("Known", 1, true, false, ok(), nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	c := tr.Dial()
+	tr.Send(c, frame(1, "routed"))
+	if got := c.Written(); len(got) != 0 {
+		t.Fatalf("routed message answered with %q, want no NACK", got)
+	}
+
+	tr.Send(c, frame(0x42, "unrouted"))
+	tr.Send(c, frame(0x07, "unrouted"))
+	want := append(nack(0x42), nack(0x07)...)
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Fatalf("NACKs = %q, want %q", got, want)
+	}
+	if c.Closed() {
+		t.Fatal("connection closed after a NACK, want it kept open")
+	}
+}
@@ -0,0 +1,132 @@
+// Package bmuxtest drives a complete bmux.Server, with its routers and
+// middleware, through an in-memory connection, for integration tests and
+// benchmarks of framing and dispatch without network noise.
+//
+//	server := bmux.New(ctxFactory, extractLen, extractID, &cfg)
+//	server.LoadRouter(routers)
+//	h := bmuxtest.New(server)
+//	replies, err := h.RoundTrip([][]byte{ping})
+package bmuxtest
+
+import (
+	"fmt"
+
+	"github.com/etwodev/bmux"
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Harness holds the engine of a server whose routes are registered once,
+// so repeated round trips measure framing and dispatch alone.
+type Harness[T any] struct {
+	e *engine.EngineWrapper[T]
+}
+
+// New registers the routes of server, as Start does, and returns a Harness
+// for it. Routers and middleware loaded afterwards are not seen.
+func New[T any](server *bmux.Server[T]) *Harness[T] {
+	return &Harness[T]{e: server.Engine()}
+}
+
+// RoundTrip is New(server).RoundTrip(frames). It registers the server's
+// routes on every call, resetting its route counters; use a Harness for
+// repeated round trips.
+func RoundTrip[T any](server *bmux.Server[T], frames [][]byte) ([][]byte, error) {
+	return New(server).RoundTrip(frames)
+}
+
+// RoundTrip opens an in-memory connection, delivers each frame in order as
+// its own traffic event, closes the connection and returns every frame
+// written to it, split on the server's own framing: its Framer if it has
+// one, the length prefix otherwise.
+//
+// RoundTrip returns an error if the connection was refused, or if the
+// written bytes do not split into whole frames. Frames after one whose
+// handler closes the connection are not delivered, as on a real
+// connection. AsyncWrite replies are included, since the in-memory conn
+// writes them inline.
+//
+// Example benchmark of a no-op handler:
+//
+//	func BenchmarkNoop(b *testing.B) {
+//		h := bmuxtest.New(server)
+//		frame, _ := parsing.Frame([]byte{0x01}, nil)
+//		frames := [][]byte{frame}
+//		for range b.N {
+//			if _, err := h.RoundTrip(frames); err != nil {
+//				b.Fatal(err)
+//			}
+//		}
+//	}
+func (h *Harness[T]) RoundTrip(frames [][]byte) ([][]byte, error) {
+	e := h.e
+
+	c := enginetest.NewConn()
+	if _, action := e.OnOpen(c); action == gnet.Close {
+		e.OnClose(c, nil)
+		return nil, fmt.Errorf("RoundTrip: connection refused")
+	}
+
+	for _, frame := range frames {
+		if enginetest.Traffic(e, c, frame) == gnet.Close {
+			_ = c.Close()
+			break
+		}
+	}
+	e.OnClose(c, nil)
+
+	return split(e, c, c.Written())
+}
+
+// split cuts out into frames using the engine's Framer or, without one,
+// its length extractor.
+func split[T any](e *engine.EngineWrapper[T], c gnet.Conn, out []byte) ([][]byte, error) {
+	var frames [][]byte
+	for len(out) > 0 {
+		size, err := nextFrame(e, c, out)
+		if err != nil {
+			return frames, err
+		}
+
+		frames = append(frames, out[:size:size])
+		out = out[size:]
+	}
+	return frames, nil
+}
+
+// nextFrame returns the size of the frame at the start of out, which is
+// always positive so that split advances.
+func nextFrame[T any](e *engine.EngineWrapper[T], c gnet.Conn, out []byte) (int, error) {
+	if e.Framer != nil {
+		size, ok := e.Framer.NextFrame(out)
+		if !ok {
+			return 0, fmt.Errorf("RoundTrip: %d trailing bytes do not form a whole frame", len(out))
+		}
+		if size <= 0 || size > len(out) {
+			return 0, fmt.Errorf("RoundTrip: framer returned an invalid frame length %d for %d bytes", size, len(out))
+		}
+		return size, nil
+	}
+
+	if len(out) < e.HeadSize {
+		return 0, fmt.Errorf("RoundTrip: %d trailing bytes are shorter than a header", len(out))
+	}
+
+	// As in the engine, whose Peek(0) returns everything buffered, an
+	// extractor without a fixed header sees all the remaining bytes.
+	prefix := out[:e.HeadSize]
+	if e.HeadSize == 0 {
+		prefix = out
+	}
+
+	_, ttl := e.ExtractLength(c, prefix)
+	size := e.HeadSize + ttl
+	if ttl < 0 || size > len(out) {
+		return 0, fmt.Errorf("RoundTrip: written frame of %d bytes is truncated to %d", size, len(out))
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("RoundTrip: extractor returned an empty frame for %d written bytes", len(out))
+	}
+	return size, nil
+}
@@ -0,0 +1,145 @@
+package bmuxtest_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/etwodev/bmux"
+	"github.com/etwodev/bmux/pkg/bmuxtest"
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/etwodev/bmux/pkg/parsing"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+type testContext struct{}
+
+func newContext() *testContext { return &testContext{} }
+
+// loadConfig makes cfg the process configuration, which bmux.New keeps.
+func loadConfig(t testing.TB, cfg config.Config) {
+	t.Helper()
+
+	cfg.LogLevel = "error"
+	if err := config.LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &cfg); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+}
+
+func routes(routes ...router.Route) []router.Router {
+	return []router.Router{router.NewRouter(true, routes, nil)}
+}
+
+// reply writes body back as a canonical frame with a single byte header 0x01.
+func reply() handler.HandlerFunc {
+	return func(c gnet.Conn, body []byte) gnet.Action {
+		packet, err := parsing.Frame([]byte{0x01}, body)
+		if err != nil {
+			return gnet.Close
+		}
+		_, _ = c.Write(packet)
+		return gnet.None
+	}
+}
+
+func firstHeadByte(_ gnet.Conn, head, _ []byte) int {
+	if len(head) == 0 {
+		return -1
+	}
+	return int(head[0])
+}
+
+func newCanonicalServer(t testing.TB) *bmux.Server[testContext] {
+	loadConfig(t, config.Default())
+
+	server := bmux.New(newContext, parsing.DefaultExtractLength[testContext](), firstHeadByte, nil)
+	server.LoadRouter(routes(router.NewRoute("Echo", 0x01, true, false, reply(), nil)))
+	return server
+}
+
+func TestRoundTripSplitsReplies(t *testing.T) {
+	h := bmuxtest.New(newCanonicalServer(t))
+
+	ping, _ := parsing.Frame([]byte{0x01}, []byte("ping"))
+	pong, _ := parsing.Frame([]byte{0x01}, []byte("pong"))
+
+	replies, err := h.RoundTrip([][]byte{ping, pong})
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if len(replies) != 2 || string(replies[0]) != string(ping) || string(replies[1]) != string(pong) {
+		t.Fatalf("RoundTrip = %q, want the two frames echoed", replies)
+	}
+}
+
+func TestRoundTripUsesFramer(t *testing.T) {
+	loadConfig(t, config.Default())
+
+	lineEcho := handler.HandlerFunc(func(c gnet.Conn, body []byte) gnet.Action {
+		_, _ = c.Write([]byte(strings.ToUpper(string(body)) + "\n"))
+		return gnet.None
+	})
+
+	server := bmux.New(newContext, parsing.DefaultExtractLength[testContext](),
+		func(gnet.Conn, []byte, []byte) int { return 0 }, nil,
+		bmux.WithFramer[testContext](parsing.LineFramer{}))
+	server.LoadRouter(routes(router.NewRoute("Echo", 0, true, false, lineEcho, nil)))
+
+	replies, err := bmuxtest.RoundTrip(server, [][]byte{[]byte("hello\nwor"), []byte("ld\n")})
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if len(replies) != 2 || string(replies[0]) != "HELLO\n" || string(replies[1]) != "WORLD\n" {
+		t.Fatalf("RoundTrip = %q, want HELLO and WORLD lines", replies)
+	}
+}
+
+func TestRoundTripRejectsEmptyFrames(t *testing.T) {
+	cfg := config.Default()
+	cfg.HeadSize = 0
+	loadConfig(t, cfg)
+
+	// Frames are as long as their first byte says.
+	server := bmux.New(newContext,
+		func(_ gnet.Conn, buf []byte) (int, int) { return 0, int(buf[0]) },
+		func(gnet.Conn, []byte, []byte) int { return 0 }, nil)
+	server.LoadRouter(routes(router.NewRoute("Raw", 0, true, false, func(c gnet.Conn, _ []byte) gnet.Action {
+		_, _ = c.Write([]byte{0})
+		return gnet.None
+	}, nil)))
+
+	if _, err := bmuxtest.RoundTrip(server, [][]byte{{1}}); err == nil {
+		t.Fatal("RoundTrip split a reply into empty frames")
+	}
+}
+
+func TestHarnessKeepsRouteCounters(t *testing.T) {
+	server := newCanonicalServer(t)
+	h := bmuxtest.New(server)
+
+	ping, _ := parsing.Frame([]byte{0x01}, nil)
+	for range 3 {
+		if _, err := h.RoundTrip([][]byte{ping}); err != nil {
+			t.Fatalf("RoundTrip: %v", err)
+		}
+	}
+
+	top := server.TopRoutes(1)
+	if len(top) != 1 || top[0].Count != 3 {
+		t.Fatalf("TopRoutes(1) = %+v, want 3 messages counted across round trips", top)
+	}
+}
+
+func BenchmarkRoundTrip(b *testing.B) {
+	h := bmuxtest.New(newCanonicalServer(b))
+	frame, _ := parsing.Frame([]byte{0x01}, []byte("ping"))
+	frames := [][]byte{frame}
+
+	for range b.N {
+		if _, err := h.RoundTrip(frames); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package clock abstracts the passage of time for bmux's time-based
+// features (idle timeouts, rate limits, queue waits, deduplication, ...),
+// so tests can drive them deterministically with a Fake instead of sleeping.
+//
+//	clk := clock.NewFake(time.Unix(0, 0))
+//	e.Clock = clk
+//	e.OnOpen(c)
+//	clk.Advance(e.IdleTimeout)
+//	e.OnTick() // closes c
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules functions to run after a delay.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine once d has elapsed, as
+	// time.AfterFunc. The returned Timer cancels the call.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call.
+type Timer interface {
+	// Stop prevents the call from running, reporting whether it did so;
+	// false means it already ran or was stopped.
+	Stop() bool
+}
+
+// Real is the Clock backed by the time package. It is the default wherever
+// a Clock is accepted.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Fake is a Clock that only moves when Advance is called. Functions
+// scheduled with AfterFunc run synchronously in Advance, in deadline
+// order, once the clock reaches their deadline. It is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewFake returns a Fake set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{clock: f, at: f.now.Add(d), fn: fn}
+	f.pending = append(f.pending, t)
+	return t
+}
+
+// Advance moves the clock forward by d, running every function that
+// became due, each with the clock set to its deadline.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		i := f.nextDueLocked(end)
+		if i < 0 {
+			f.now = end
+			f.mu.Unlock()
+			return
+		}
+
+		t := f.pending[i]
+		f.pending = slices.Delete(f.pending, i, i+1)
+		f.now = t.at
+		f.mu.Unlock()
+
+		// Run unlocked: fn may well read the clock or schedule again.
+		t.fn()
+	}
+}
+
+// nextDueLocked returns the index of the earliest timer due by end, or -1.
+func (f *Fake) nextDueLocked(end time.Time) int {
+	next := -1
+	for i, t := range f.pending {
+		if t.at.After(end) {
+			continue
+		}
+		if next < 0 || t.at.Before(f.pending[next].at) {
+			next = i
+		}
+	}
+	return next
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	i := slices.Index(t.clock.pending, t)
+	if i < 0 {
+		return false
+	}
+	t.clock.pending = slices.Delete(t.clock.pending, i, i+1)
+	return true
+}
@@ -0,0 +1,36 @@
+package clock_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+)
+
+func TestFakeRunsTimersInDeadlineOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	clk := clock.NewFake(start)
+
+	var fired []time.Duration
+	record := func() { fired = append(fired, clk.Now().Sub(start)) }
+	clk.AfterFunc(3*time.Second, record)
+	clk.AfterFunc(time.Second, record)
+	stopped := clk.AfterFunc(2*time.Second, record)
+	clk.AfterFunc(10*time.Second, record)
+
+	if !stopped.Stop() {
+		t.Fatal("Stop() = false for a pending timer")
+	}
+
+	clk.Advance(5 * time.Second)
+	if want := []time.Duration{time.Second, 3 * time.Second}; !slices.Equal(fired, want) {
+		t.Fatalf("timers fired at %v, want %v", fired, want)
+	}
+	if got := clk.Now().Sub(start); got != 5*time.Second {
+		t.Fatalf("Now() after Advance = %v, want 5s", got)
+	}
+	if stopped.Stop() {
+		t.Fatal("Stop() = true for a timer already stopped")
+	}
+}
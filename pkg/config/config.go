@@ -1,48 +1,190 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 const CONFIG_PATH = "./bmux.config.json"
 
-var c *Config
-
-// Load reads the configuration file from disk, parses the JSON content,
-// and loads it into the package-level Config variable `c`.
+// DisableAutoCreate stops Load from writing a config file when none exists.
+// A missing file is then an error, unless an override is supplied, in which
+// case the override is applied over the Default values, just as the file
+// auto-created from it would be, without touching disk.
 //
-// If the config file does not exist, it will attempt to create one with default values.
+// Defaults to false, preserving the original auto-create behaviour.
+var DisableAutoCreate = false
+
+// MaxSize is the largest config file, in bytes, that Load accepts. Larger
+// files are rejected before being read, guarding against a corrupted or
+// misplaced file exhausting memory. Zero or less disables the check.
 //
-// Returns an error if reading or unmarshalling the file fails.
+// Defaults to 1 MiB.
+var MaxSize int64 = 1 << 20
+
+// LoadStats describes the most recent successful Load.
+type LoadStats struct {
+	Path string        // File the configuration was read from
+	Size int64         // File size in bytes, 0 if no file was read
+	Took time.Duration // Time spent reading and parsing the file
+}
+
+// stats holds the LoadStats of the most recent successful Load.
+var stats atomic.Pointer[LoadStats]
+
+// LastLoad returns the LoadStats of the most recent successful Load, or
+// the zero LoadStats if no configuration has been loaded yet.
+func LastLoad() LoadStats {
+	if st := stats.Load(); st != nil {
+		return *st
+	}
+	return LoadStats{}
+}
+
+// c holds the current configuration. Readers always go through Load so a
+// reload can publish a new Config without racing concurrent accessors.
+var c atomic.Pointer[Config]
+
+// Load reads the configuration file at CONFIG_PATH. See LoadFrom.
 //
 // Example usage:
 //
-//	err := config.Load()
+//	err := config.Load(nil)
 //	if err != nil {
 //	    // handle error
 //	}
 func Load(override *Config) error {
-	_, err := os.Stat(CONFIG_PATH)
+	return LoadFrom(CONFIG_PATH, override)
+}
+
+// LoadFrom reads the configuration file at path, parses the JSON content
+// over the Default values, and atomically publishes it as the current configuration.
+//
+// If the config file does not exist, it will attempt to create one with default values,
+// unless DisableAutoCreate is set.
+//
+// Returns an error if the file is larger than MaxSize, or if reading or
+// unmarshalling it fails. The size and duration of a successful load are
+// reported by LastLoad.
+//
+// Example usage:
+//
+//	err := config.LoadFrom("/etc/bmux/config.json", nil)
+//	if err != nil {
+//	    // handle error
+//	}
+func LoadFrom(path string, override *Config) error {
+	start := time.Now()
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && DisableAutoCreate {
+		if override == nil {
+			return fmt.Errorf("Load: config file %s does not exist and auto-create is disabled", path)
+		}
+
+		cfg, err := overDefault(override)
+		if err != nil {
+			return fmt.Errorf("Load: failed applying override: %w", err)
+		}
+
+		c.Store(&cfg)
+		stats.Store(&LoadStats{Path: path, Took: time.Since(start)})
+		return nil
+	}
+
+	if err == nil && MaxSize > 0 && info.Size() > MaxSize {
+		return fmt.Errorf("Load: config file %s is %d bytes, exceeding the %d byte limit", path, info.Size(), MaxSize)
+	}
+
 	if os.IsNotExist(err) {
-		if err := Create(override); err != nil {
+		if err := CreateAt(path, override); err != nil {
 			return fmt.Errorf("Load: failed creating config: %w", err)
 		}
 	}
 
-	file, err := os.ReadFile(CONFIG_PATH)
+	file, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("Load: failed reading json: %w", err)
 	}
 
-	err = json.Unmarshal(file, &c)
+	cfg := Default()
+	err = json.Unmarshal(file, &cfg)
 	if err != nil {
+		if line, col, ok := errorPosition(file, err); ok {
+			return fmt.Errorf("Load: failed unmarshalling json at %s:%d:%d: %w", path, line, col, err)
+		}
 		return fmt.Errorf("Load: failed unmarshalling json: %w", err)
 	}
+
+	c.Store(&cfg)
+	stats.Store(&LoadStats{Path: path, Size: int64(len(file)), Took: time.Since(start)})
 	return nil
 }
 
+// overDefault applies override over the Default values exactly as a config
+// file written from it would be, so a missing file gives the same config
+// with or without DisableAutoCreate.
+func overDefault(override *Config) (Config, error) {
+	raw, err := json.Marshal(override)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	err = json.Unmarshal(raw, &cfg)
+	return cfg, err
+}
+
+// errorPosition translates the byte offset carried by JSON syntax and type
+// errors into a 1-based line and column within data.
+func errorPosition(data []byte, err error) (line, col int, ok bool) {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0, 0, false
+	}
+
+	// Offset counts the bytes read up to and including the offending one.
+	offset = min(max(offset-1, 0), int64(len(data)))
+
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col, true
+}
+
+// Default returns the configuration used when no file exists. Fields
+// missing from a config file are also backfilled from it on Load.
+func Default() Config {
+	enableTCPNoDelay := true
+	return Config{
+		Port:             30000,
+		Protocol:         "tcp://",
+		Address:          "0.0.0.0",
+		Experimental:     false,
+		LogLevel:         "info",
+		MaxConnections:   1024,
+		HeadSize:         3,
+		ShutdownTimeout:  10,
+		EnableMulticore:  true,
+		EnableTCPNoDelay: &enableTCPNoDelay,
+		LogFormat:        "console",
+		LogOutput:        "stdout",
+	}
+}
+
 // Create writes a configuration file with either default values or
 // overrides provided by the user.
 //
@@ -52,18 +194,18 @@ func Load(override *Config) error {
 //
 // Example usage:
 //
-//	err := config.Create(&config.Config{Port: "8080"})
+//	err := config.Create(&config.Config{Port: 8080})
 func Create(override *Config) error {
-	defaultConfig := Config{
-		Port:            30000,
-		Protocol:        "tcp://",
-		Address:         "0.0.0.0",
-		Experimental:    false,
-		LogLevel:        "info",
-		MaxConnections:  1024,
-		ShutdownTimeout: 10,
-		EnableMulticore: true,
-	}
+	return CreateAt(CONFIG_PATH, override)
+}
+
+// CreateAt is like Create but writes the configuration file to path.
+//
+// Example usage:
+//
+//	err := config.CreateAt("/etc/bmux/config.json", nil)
+func CreateAt(path string, override *Config) error {
+	defaultConfig := Default()
 
 	if override != nil {
 		defaultConfig = *override
@@ -74,7 +216,7 @@ func Create(override *Config) error {
 		return fmt.Errorf("Create: failed marshalling config: %w", err)
 	}
 
-	err = os.WriteFile(CONFIG_PATH, file, 0644)
+	err = os.WriteFile(path, file, 0644)
 	if err != nil {
 		return fmt.Errorf("Create: failed writing config: %w", err)
 	}
@@ -82,20 +224,29 @@ func Create(override *Config) error {
 	return nil
 }
 
-// New initializes the package configuration by loading the config file,
-// if it hasn't already been loaded.
+// New initializes the package configuration by loading the config file
+// at CONFIG_PATH, if it hasn't already been loaded.
 //
 // Returns an error if loading the configuration fails.
 //
 // Example usage:
 //
-//	err := config.New()
+//	err := config.New(nil)
 //	if err != nil {
 //	    // handle error
 //	}
 func New(override *Config) error {
-	if c == nil {
-		err := Load(override)
+	return NewFrom(CONFIG_PATH, override)
+}
+
+// NewFrom is like New but loads the config file at path.
+//
+// Example usage:
+//
+//	err := config.NewFrom("/etc/bmux/config.json", nil)
+func NewFrom(path string, override *Config) error {
+	if c.Load() == nil {
+		err := LoadFrom(path, override)
 		if err != nil {
 			return fmt.Errorf("New: failed loading json: %w", err)
 		}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOverrideKeepsOSSocketDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bmux.config.json")
+	override := &Config{Port: 40000, HeadSize: 3}
+
+	if err := LoadFrom(path, override); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	if EnableSOLinger() {
+		t.Error("EnableSOLinger() = true for an override that does not set it")
+	}
+	if !EnableTCPNoDelay() {
+		t.Error("EnableTCPNoDelay() = false for an override that does not set it")
+	}
+}
+
+// TestAccessorsDuringReload reads accessors while reloads swap the current
+// config; run with -race.
+func TestAccessorsDuringReload(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	ports := []int{40001, 40002}
+	for i, path := range paths {
+		if err := CreateAt(path, &Config{Port: ports[i], HeadSize: 3}); err != nil {
+			t.Fatalf("CreateAt: %v", err)
+		}
+	}
+	if err := LoadFrom(paths[0], nil); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				if p := Port(); p != ports[0] && p != ports[1] {
+					t.Errorf("Port() = %d during reload, want %d or %d", p, ports[0], ports[1])
+					return
+				}
+				_ = Snapshot()
+				_ = HeadSize()
+			}
+		}()
+	}
+
+	for i := range 100 {
+		if err := LoadFrom(paths[i%2], nil); err != nil {
+			t.Fatalf("LoadFrom: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestEnableTCPNoDelayFromFile(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want bool
+	}{
+		{`{"port": 40000}`, true},
+		{`{"port": 40000, "enableTCPNoDelay": null}`, true},
+		{`{"port": 40000, "enableTCPNoDelay": false}`, false},
+		{`{"port": 40000, "enableTCPNoDelay": true}`, true},
+	} {
+		path := filepath.Join(t.TempDir(), "bmux.config.json")
+		if err := os.WriteFile(path, []byte(tc.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadFrom(path, nil); err != nil {
+			t.Fatalf("LoadFrom(%s): %v", tc.file, err)
+		}
+
+		if got := EnableTCPNoDelay(); got != tc.want {
+			t.Errorf("EnableTCPNoDelay() = %v for %s, want %v", got, tc.file, tc.want)
+		}
+	}
+}
+
+func TestOverridePathsAgree(t *testing.T) {
+	override := Config{Port: 40000, HeadSize: 3, LogLevel: "error"}
+
+	if err := LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &override); err != nil {
+		t.Fatalf("LoadFrom with auto-create: %v", err)
+	}
+	created := Snapshot()
+
+	DisableAutoCreate = true
+	defer func() { DisableAutoCreate = false }()
+	path := filepath.Join(t.TempDir(), "bmux.config.json")
+	if err := LoadFrom(path, &override); err != nil {
+		t.Fatalf("LoadFrom without auto-create: %v", err)
+	}
+	inMemory := Snapshot()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("DisableAutoCreate wrote %s", path)
+	}
+	if !reflect.DeepEqual(created, inMemory) {
+		t.Fatalf("override loaded as %+v without auto-create, want %+v as with it", inMemory, created)
+	}
+	if !EnableTCPNoDelay() {
+		t.Error("EnableTCPNoDelay() = false for an override that does not set it")
+	}
+}
+
+func TestMissingFileAutoCreated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bmux.config.json")
+	if err := LoadFrom(path, nil); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("config file not created: %v", err)
+	}
+	if got, want := Snapshot(), Default(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("auto-created config = %+v, want Default %+v", got, want)
+	}
+}
+
+func TestMissingFileRejectedWithoutAutoCreate(t *testing.T) {
+	DisableAutoCreate = true
+	defer func() { DisableAutoCreate = false }()
+
+	if err := LoadFrom(filepath.Join(t.TempDir(), "loaded.json"), &Config{Port: 40000}); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "bmux.config.json")
+	if err := LoadFrom(path, nil); err == nil {
+		t.Fatal("LoadFrom succeeded for a missing file with auto-create disabled")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("DisableAutoCreate wrote %s", path)
+	}
+	if got := Port(); got != 40000 {
+		t.Errorf("Port() = %d after a failed load, want the previous config kept (40000)", got)
+	}
+}
+
+func TestLoadFromCustomPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etc", "bmux", "server.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"port": 41000, "address": "127.0.0.1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadFrom(path, nil); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	if Port() != 41000 || Address() != "127.0.0.1" {
+		t.Errorf("Port, Address = %d, %q, want the values from %s", Port(), Address(), path)
+	}
+	if HeadSize() != Default().HeadSize {
+		t.Errorf("HeadSize() = %d, want the default backfilled", HeadSize())
+	}
+	if got := LastLoad().Path; got != path {
+		t.Errorf("LastLoad().Path = %q, want %q", got, path)
+	}
+	if _, err := os.Stat(CONFIG_PATH); !os.IsNotExist(err) {
+		t.Errorf("loading %s touched %s", path, CONFIG_PATH)
+	}
+}
+
+func TestMalformedFileReportsPosition(t *testing.T) {
+	for _, tc := range []struct {
+		file, want string
+	}{
+		{"{\n  \"port\": 40000,\n  \"address\": \"0.0.0.0\"\n  \"logLevel\": \"info\"\n}", ":4:3:"},
+		{"{\n  \"port\": \"forty\"\n}", ":2:17:"},
+		{"{\"port\": 40000,}", ":1:16:"},
+	} {
+		path := filepath.Join(t.TempDir(), "bmux.config.json")
+		if err := os.WriteFile(path, []byte(tc.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := LoadFrom(path, nil)
+		if err == nil {
+			t.Fatalf("LoadFrom accepted %q", tc.file)
+		}
+		if !strings.Contains(err.Error(), path+tc.want) {
+			t.Errorf("LoadFrom(%q) = %v, want the position %s%s", tc.file, err, path, tc.want)
+		}
+	}
+}
+
+func TestOversizedFileRejected(t *testing.T) {
+	defer func(size int64) { MaxSize = size }(MaxSize)
+
+	small := filepath.Join(t.TempDir(), "small.json")
+	file := []byte(`{"port": 40000}`)
+	if err := os.WriteFile(small, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	MaxSize = int64(len(file))
+	if err := LoadFrom(small, nil); err != nil {
+		t.Fatalf("LoadFrom at MaxSize: %v", err)
+	}
+	if st := LastLoad(); st.Path != small || st.Size != int64(len(file)) || st.Took <= 0 {
+		t.Errorf("LastLoad = %+v, want %s with %d bytes and a duration", st, small, len(file))
+	}
+
+	large := filepath.Join(t.TempDir(), "large.json")
+	if err := os.WriteFile(large, []byte(`{"port": 40001, "address": "127.0.0.1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := LoadFrom(large, nil)
+	if err == nil || !strings.Contains(err.Error(), "byte limit") {
+		t.Fatalf("LoadFrom over MaxSize = %v, want the size error", err)
+	}
+	if Port() != 40000 || LastLoad().Path != small {
+		t.Errorf("rejected file replaced the config: Port() = %d, LastLoad().Path = %s", Port(), LastLoad().Path)
+	}
+
+	MaxSize = 0
+	if err := LoadFrom(large, nil); err != nil {
+		t.Fatalf("LoadFrom with the size check disabled: %v", err)
+	}
+}
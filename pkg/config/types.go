@@ -2,23 +2,86 @@ package config
 
 // Config defines network-level configuration options.
 type Config struct {
-	Port            int    `json:"port"`            // Listening port (defaults to 30000)
-	Protocol        string `json:"protocol"`        // What protocol to use (defaults to tcp://)
-	Address         string `json:"address"`         // Bind address (defaults to 0.0.0.0)
-	Experimental    bool   `json:"experimental"`    // Enable experimental routes (defaults to false)
-	LogLevel        string `json:"logLevel"`        // Logging level (defaults to info)
-	MaxConnections  int    `json:"maxConnections"`  // Maximum simultaneous connections (defaults to 1024)
-	HeadSize        int    `json:"headSize"`        // The size of the header in bytes (defaults to 3)
-	ShutdownTimeout int    `json:"shutdownTimeout"` // Graceful shutdown timeout in seconds (defaults to 15)
-	EnableMulticore bool   `json:"enableMulticore"` // Whether to use multiple cores for the server (defaults to true)
+	Port                  int    `json:"port"`                  // Listening port (defaults to 30000)
+	Protocol              string `json:"protocol"`              // What protocol to use (defaults to tcp://)
+	Address               string `json:"address"`               // Bind address (defaults to 0.0.0.0)
+	Experimental          bool   `json:"experimental"`          // Enable experimental routes (defaults to false)
+	LogLevel              string `json:"logLevel"`              // Logging level (defaults to info)
+	MaxConnections        int    `json:"maxConnections"`        // Maximum simultaneous connections (defaults to 1024)
+	HeadSize              int    `json:"headSize"`              // The size of the header in bytes (defaults to 3)
+	ShutdownTimeout       int    `json:"shutdownTimeout"`       // Graceful shutdown timeout in seconds (defaults to 15)
+	EnableMulticore       bool   `json:"enableMulticore"`       // Whether to use multiple cores for the server (defaults to true)
+	NumEventLoops         int    `json:"numEventLoops"`         // Number of gnet event loops, 0 leaves it to gnet (defaults to 0)
+	UDPPort               int    `json:"udpPort"`               // Additional UDP listener port serving the same routes, 0 disables (defaults to 0)
+	MaxWriteFailures      int    `json:"maxWriteFailures"`      // Consecutive failed async writes before a connection is closed, 0 disables (defaults to 0)
+	StrictRouting         bool   `json:"strictRouting"`         // Refuse to start when no routes are registered, or more than maxRoutes (defaults to false)
+	SocketRecvBuffer      int    `json:"socketRecvBuffer"`      // SO_RCVBUF size in bytes, 0 keeps the OS default (defaults to 0)
+	SocketSendBuffer      int    `json:"socketSendBuffer"`      // SO_SNDBUF size in bytes, 0 keeps the OS default (defaults to 0)
+	EnableTCPNoDelay      *bool  `json:"enableTCPNoDelay"`      // Disable Nagle's algorithm on accepted connections, unset means true (defaults to true)
+	LogFormat             string `json:"logFormat"`             // Log format, console or json (defaults to console)
+	LogOutput             string `json:"logOutput"`             // Log destination, stdout, stderr or a file path (defaults to stdout)
+	LogHandlerCloses      bool   `json:"logHandlerCloses"`      // Log (at debug) when a handler returns gnet.Close (defaults to false)
+	IdleTimeout           int    `json:"idleTimeout"`           // Seconds without traffic before a connection is closed, 0 disables (defaults to 0)
+	IdleWarningTimeout    int    `json:"idleWarningTimeout"`    // Seconds without traffic before OnIdleWarning fires, must be below idleTimeout, 0 disables (defaults to 0)
+	WriteCoalesceWindow   int    `json:"writeCoalesceWindow"`   // Microseconds to buffer outbound frames sent via Server.Send, 0 disables (defaults to 0)
+	WriteCoalesceBytes    int    `json:"writeCoalesceBytes"`    // Buffered bytes that trigger an early flush, 0 flushes on the window only (defaults to 0)
+	MaxFramesPerSecond    int    `json:"maxFramesPerSecond"`    // Frames per second allowed per connection, 0 disables (defaults to 0)
+	CloseOnFrameLimit     bool   `json:"closeOnFrameLimit"`     // Close connections over maxFramesPerSecond instead of dropping frames (defaults to false)
+	MaxConcurrentHandlers int    `json:"maxConcurrentHandlers"` // Handlers allowed to run at once across all connections, 0 disables (defaults to 0)
+	HandlerQueueWait      int    `json:"handlerQueueWait"`      // Milliseconds a frame waits for a free handler slot before it is dropped, 0 drops immediately (defaults to 0)
+	MaxAcceptsPerSecond   int    `json:"maxAcceptsPerSecond"`   // New connections accepted per second across the server, 0 disables (defaults to 0)
+	AcceptBurst           int    `json:"acceptBurst"`           // Connections accepted in a burst above maxAcceptsPerSecond, 0 uses maxAcceptsPerSecond (defaults to 0)
+	MaxPendingInbound     int    `json:"maxPendingInbound"`     // Bytes a connection may buffer without completing a frame before it is closed, 0 disables (defaults to 0)
+	WarnLogBurst          int    `json:"warnLogBurst"`          // Bad frame warnings logged per connection before sampling starts, 0 logs every warning (defaults to 0)
+	WarnLogSample         int    `json:"warnLogSample"`         // Once warnLogBurst is reached, log one in this many bad frame warnings, 0 logs none (defaults to 0)
+	WarnLogWindow         int    `json:"warnLogWindow"`         // Seconds after which a connection's warnLogBurst budget is restored, 0 uses one second (defaults to 0)
+	EnableSOLinger        bool   `json:"enableSOLinger"`        // Apply soLinger to accepted TCP connections instead of the OS default (defaults to false)
+	SOLinger              int    `json:"soLinger"`              // SO_LINGER seconds when enableSOLinger is set: 0 discards unsent data on close (defaults to 0)
+	MaxRoutes             int    `json:"maxRoutes"`             // Soft limit on registered routes: exceeding it warns, or refuses to start under strictRouting; 0 disables (defaults to 0)
 }
 
-func Port() int             { return c.Port }
-func Protocol() string      { return c.Protocol }
-func Address() string       { return c.Address }
-func Experimental() bool    { return c.Experimental }
-func LogLevel() string      { return c.LogLevel }
-func MaxConnections() int   { return c.MaxConnections }
-func HeadSize() int         { return c.HeadSize }
-func ShutdownTimeout() int  { return c.ShutdownTimeout }
-func EnableMulticore() bool { return c.EnableMulticore }
+// Snapshot returns a copy of the current configuration.
+func Snapshot() Config { return *c.Load() }
+
+func Port() int                  { return c.Load().Port }
+func Protocol() string           { return c.Load().Protocol }
+func Address() string            { return c.Load().Address }
+func Experimental() bool         { return c.Load().Experimental }
+func LogLevel() string           { return c.Load().LogLevel }
+func MaxConnections() int        { return c.Load().MaxConnections }
+func HeadSize() int              { return c.Load().HeadSize }
+func ShutdownTimeout() int       { return c.Load().ShutdownTimeout }
+func EnableMulticore() bool      { return c.Load().EnableMulticore }
+func NumEventLoops() int         { return c.Load().NumEventLoops }
+func UDPPort() int               { return c.Load().UDPPort }
+func MaxWriteFailures() int      { return c.Load().MaxWriteFailures }
+func StrictRouting() bool        { return c.Load().StrictRouting }
+func SocketRecvBuffer() int      { return c.Load().SocketRecvBuffer }
+func SocketSendBuffer() int      { return c.Load().SocketSendBuffer }
+func LogFormat() string          { return c.Load().LogFormat }
+func LogOutput() string          { return c.Load().LogOutput }
+func LogHandlerCloses() bool     { return c.Load().LogHandlerCloses }
+func IdleTimeout() int           { return c.Load().IdleTimeout }
+func IdleWarningTimeout() int    { return c.Load().IdleWarningTimeout }
+func WriteCoalesceWindow() int   { return c.Load().WriteCoalesceWindow }
+func WriteCoalesceBytes() int    { return c.Load().WriteCoalesceBytes }
+func MaxFramesPerSecond() int    { return c.Load().MaxFramesPerSecond }
+func CloseOnFrameLimit() bool    { return c.Load().CloseOnFrameLimit }
+func MaxConcurrentHandlers() int { return c.Load().MaxConcurrentHandlers }
+func HandlerQueueWait() int      { return c.Load().HandlerQueueWait }
+func MaxAcceptsPerSecond() int   { return c.Load().MaxAcceptsPerSecond }
+func AcceptBurst() int           { return c.Load().AcceptBurst }
+func MaxPendingInbound() int     { return c.Load().MaxPendingInbound }
+func WarnLogBurst() int          { return c.Load().WarnLogBurst }
+func WarnLogSample() int         { return c.Load().WarnLogSample }
+func WarnLogWindow() int         { return c.Load().WarnLogWindow }
+func EnableSOLinger() bool       { return c.Load().EnableSOLinger }
+func SOLinger() int              { return c.Load().SOLinger }
+func MaxRoutes() int             { return c.Load().MaxRoutes }
+
+// EnableTCPNoDelay reports whether Nagle's algorithm is disabled, which is
+// the case unless enableTCPNoDelay is explicitly false.
+func EnableTCPNoDelay() bool {
+	v := c.Load().EnableTCPNoDelay
+	return v == nil || *v
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Direction tells whether a captured frame was received or sent.
+type Direction uint8
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+// CaptureRecord is a single frame recorded by a Capture.
+type CaptureRecord struct {
+	Time      time.Time
+	ConnID    string
+	Direction Direction
+	MsgID     int    // -1 when unknown, e.g. for packets sent with AsyncWrite
+	Frame     []byte // raw frame exactly as on the wire
+}
+
+// captureFixed is the encoded size of the fixed-width record fields:
+// time (8), direction (1), msgID (8) and the conn ID length (2).
+const captureFixed = 8 + 1 + 8 + 2
+
+// MarshalBinary encodes r as stored in a capture, without the length
+// prefix:
+//
+//	| time (8, unix nanos) | direction (1) | msgID (8) | connIDLen (2) | connID | frame |
+//
+// Integers are big endian.
+func (r CaptureRecord) MarshalBinary() ([]byte, error) {
+	if len(r.ConnID) > 1<<16-1 {
+		return nil, errors.New("MarshalBinary: connection ID is too long")
+	}
+
+	b := make([]byte, 0, captureFixed+len(r.ConnID)+len(r.Frame))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.Time.UnixNano()))
+	b = append(b, byte(r.Direction))
+	b = binary.BigEndian.AppendUint64(b, uint64(int64(r.MsgID)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.ConnID)))
+	b = append(b, r.ConnID...)
+	b = append(b, r.Frame...)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a record produced by MarshalBinary.
+func (r *CaptureRecord) UnmarshalBinary(b []byte) error {
+	if len(b) < captureFixed {
+		return errors.New("UnmarshalBinary: record is truncated")
+	}
+
+	idLen := int(binary.BigEndian.Uint16(b[17:19]))
+	if len(b) < captureFixed+idLen {
+		return errors.New("UnmarshalBinary: record is truncated")
+	}
+
+	r.Time = time.Unix(0, int64(binary.BigEndian.Uint64(b[0:8])))
+	r.Direction = Direction(b[8])
+	r.MsgID = int(int64(binary.BigEndian.Uint64(b[9:17])))
+	r.ConnID = string(b[captureFixed : captureFixed+idLen])
+	r.Frame = append([]byte(nil), b[captureFixed+idLen:]...)
+	return nil
+}
+
+// Capture writes CaptureRecords to an io.Writer, each preceded by its
+// length as a 4 byte big endian integer. It is safe for concurrent use;
+// parsing.ReplayCapture reads the stream back.
+type Capture struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewCapture returns a Capture writing to w.
+func NewCapture(w io.Writer) *Capture {
+	return &Capture{w: w}
+}
+
+// Record appends rec to the capture.
+func (c *Capture) Record(rec CaptureRecord) error {
+	body, err := rec.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 0, 4+len(body))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	b = append(b, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = c.w.Write(b)
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// capture records frame on c when a Capture is configured. Failures are
+// logged and never affect the connection.
+func (e *EngineWrapper[T]) capture(c gnet.Conn, dir Direction, msgID int, frame []byte) {
+	if e.Capture == nil {
+		return
+	}
+
+	err := e.Capture.Record(CaptureRecord{
+		Time:      e.now(),
+		ConnID:    e.ConnID(c),
+		Direction: dir,
+		MsgID:     msgID,
+		Frame:     frame,
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to record captured frame")
+	}
+}
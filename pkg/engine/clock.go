@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+)
+
+// now returns the current time of the engine's Clock.
+func (e *EngineWrapper[T]) now() time.Time {
+	if e.Clock == nil {
+		return clock.Real.Now()
+	}
+	return e.Clock.Now()
+}
+
+// afterFunc schedules f on the engine's Clock.
+func (e *EngineWrapper[T]) afterFunc(d time.Duration, f func()) clock.Timer {
+	if e.Clock == nil {
+		return clock.Real.AfterFunc(d, f)
+	}
+	return e.Clock.AfterFunc(d, f)
+}
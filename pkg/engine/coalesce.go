@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// coalescer buffers outbound frames for one connection so that bursts of
+// small frames leave in a single write.
+type coalescer struct {
+	mu      sync.Mutex
+	pending []byte
+	armed   bool
+}
+
+// coalesce appends packet to the pending buffer of c. The buffer is
+// flushed when it reaches WriteCoalesceBytes, or WriteCoalesceWindow after
+// the first frame was queued, whichever comes first. Frames keep their
+// order because they share a single buffer and flushes happen under its lock.
+func (e *EngineWrapper[T]) coalesce(c gnet.Conn, st *connState, packet []byte) error {
+	st.out.mu.Lock()
+	defer st.out.mu.Unlock()
+
+	st.out.pending = append(st.out.pending, packet...)
+
+	if e.WriteCoalesceBytes > 0 && len(st.out.pending) >= e.WriteCoalesceBytes {
+		return e.flushLocked(c, st)
+	}
+
+	if !st.out.armed {
+		st.out.armed = true
+		e.afterFunc(e.WriteCoalesceWindow, func() {
+			st.out.mu.Lock()
+			defer st.out.mu.Unlock()
+
+			st.out.armed = false
+			_ = e.flushLocked(c, st)
+		})
+	}
+	return nil
+}
+
+func (e *EngineWrapper[T]) flushLocked(c gnet.Conn, st *connState) error {
+	if len(st.out.pending) == 0 {
+		return nil
+	}
+
+	buf := st.out.pending
+	st.out.pending = nil
+	return e.asyncWrite(c, buf)
+}
@@ -0,0 +1,111 @@
+package engine_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// countingConn counts the writes queued on it.
+type countingConn struct {
+	*enginetest.Conn
+	writes int
+}
+
+func (c *countingConn) AsyncWrite(p []byte, callback gnet.AsyncCallback) error {
+	c.writes++
+	return c.Conn.AsyncWrite(p, callback)
+}
+
+func TestCoalescedFramesLeaveInOneWrite(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.Clock = clk
+	e.WriteCoalesceWindow = time.Millisecond
+
+	c := &countingConn{Conn: enginetest.NewConn()}
+	e.OnOpen(c)
+
+	var want []byte
+	for _, body := range []string{"one", "two", "three"} {
+		f := frame(0, body)
+		want = append(want, f...)
+		if err := e.AsyncWrite(c, f); err != nil {
+			t.Fatalf("AsyncWrite: %v", err)
+		}
+	}
+	if c.writes != 0 {
+		t.Fatalf("%d writes before the window elapsed, want 0", c.writes)
+	}
+
+	clk.Advance(time.Millisecond)
+	if c.writes != 1 {
+		t.Fatalf("%d writes after the window, want 1", c.writes)
+	}
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Fatalf("Written = %q, want the frames intact and in order %q", got, want)
+	}
+}
+
+func TestCoalesceBytesFlushEarly(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.Clock = clk
+	e.WriteCoalesceWindow = time.Second
+	e.WriteCoalesceBytes = 10
+
+	c := &countingConn{Conn: enginetest.NewConn()}
+	e.OnOpen(c)
+
+	_ = e.AsyncWrite(c, frame(0, "ab"))   // 6 bytes pending
+	_ = e.AsyncWrite(c, frame(0, "cdef")) // 14 bytes, flushed
+	if c.writes != 1 {
+		t.Fatalf("%d writes once WriteCoalesceBytes was reached, want 1", c.writes)
+	}
+
+	_ = e.AsyncWrite(c, frame(0, "g"))
+	clk.Advance(time.Second)
+	if c.writes != 2 {
+		t.Fatalf("%d writes after the window, want the remainder flushed (2)", c.writes)
+	}
+
+	want := append(append(frame(0, "ab"), frame(0, "cdef")...), frame(0, "g")...)
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Fatalf("Written = %q, want %q", got, want)
+	}
+}
+
+func BenchmarkAsyncWrite(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		window time.Duration
+	}{
+		{"direct", 0},
+		{"coalesced", time.Millisecond},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+			e.Clock = clk
+			e.WriteCoalesceWindow = bc.window
+
+			c := enginetest.NewConn()
+			e.OnOpen(c)
+			f := frame(0, "state update")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = e.AsyncWrite(c, f)
+				if i%64 == 63 {
+					clk.Advance(bc.window)
+					c.Reset()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package engine
+
+import "github.com/panjf2000/gnet/v2"
+
+// acquireHandler takes a global handler slot when MaxConcurrentHandlers is
+// set. If none is free it waits up to HandlerQueueWait, blocking the event
+// loop meanwhile, and otherwise rejects the frame. The returned release
+// must be called once the handler has returned.
+//
+// Handlers run inline on the event loops, so at most one handler per loop
+// runs at a time; the cap only bites when it is below the number of loops.
+func (e *EngineWrapper[T]) acquireHandler(c gnet.Conn, id int) (release func(), ok bool) {
+	if e.MaxConcurrentHandlers <= 0 {
+		return func() {}, true
+	}
+
+	e.handlerSlotsOnce.Do(func() {
+		e.handlerSlots = make(chan struct{}, e.MaxConcurrentHandlers)
+	})
+
+	select {
+	case e.handlerSlots <- struct{}{}:
+		return e.releaseHandler, true
+	default:
+	}
+
+	if e.HandlerQueueWait > 0 {
+		expired := make(chan struct{})
+		timer := e.afterFunc(e.HandlerQueueWait, func() { close(expired) })
+		defer timer.Stop()
+
+		select {
+		case e.handlerSlots <- struct{}{}:
+			return e.releaseHandler, true
+		case <-expired:
+		}
+	}
+
+	log.Warn().
+		Str("remote", c.RemoteAddr().String()).
+		Str("conn", e.ConnID(c)).
+		Int("msgID", id).
+		Int("limit", e.MaxConcurrentHandlers).
+		Msg("concurrent handler limit reached, dropping frame")
+
+	return nil, false
+}
+
+func (e *EngineWrapper[T]) releaseHandler() {
+	<-e.handlerSlots
+}
@@ -0,0 +1,116 @@
+package engine_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// gate returns a handler for ID 1 that blocks until release is signalled,
+// and one for ID 2 that counts its calls.
+func gate(entered, release chan struct{}, handled *atomic.Int32) map[int]handler.HandlerFunc {
+	return map[int]handler.HandlerFunc{
+		1: func(gnet.Conn, []byte) gnet.Action {
+			entered <- struct{}{}
+			<-release
+			return gnet.None
+		},
+		2: func(gnet.Conn, []byte) gnet.Action {
+			handled.Add(1)
+			return gnet.None
+		},
+	}
+}
+
+func TestMaxConcurrentHandlersDropsOverCeiling(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	var handled atomic.Int32
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, gate(entered, release, &handled))
+	e.MaxConcurrentHandlers = 1
+
+	busy, other := enginetest.NewConn(), enginetest.NewConn()
+	e.OnOpen(busy)
+	e.OnOpen(other)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		enginetest.Traffic(e, busy, frame(1, ""))
+	}()
+	<-entered
+
+	if action := enginetest.Traffic(e, other, frame(2, "")); action != gnet.None || handled.Load() != 0 {
+		t.Fatalf("frame over the ceiling: action %v, handled %d, want gnet.None and dropped", action, handled.Load())
+	}
+
+	close(release)
+	<-done
+	enginetest.Traffic(e, other, frame(2, ""))
+	if handled.Load() != 1 {
+		t.Fatalf("frame after the slot was released: handled %d, want 1", handled.Load())
+	}
+}
+
+func TestMaxConcurrentHandlersQueueWait(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	entered, release := make(chan struct{}), make(chan struct{})
+	var handled atomic.Int32
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, gate(entered, release, &handled))
+	e.Clock = clk
+	e.MaxConcurrentHandlers = 1
+	e.HandlerQueueWait = 10 * time.Millisecond
+
+	busy, queued := enginetest.NewConn(), enginetest.NewConn()
+	e.OnOpen(busy)
+	e.OnOpen(queued)
+
+	hold := func() chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			enginetest.Traffic(e, busy, frame(1, ""))
+		}()
+		<-entered
+		return done
+	}
+
+	// A slot freed within the wait is taken by the queued frame.
+	busyDone := hold()
+	queuedDone := make(chan struct{})
+	go func() {
+		defer close(queuedDone)
+		enginetest.Traffic(e, queued, frame(2, ""))
+	}()
+	release <- struct{}{}
+	<-busyDone
+	<-queuedDone
+	if handled.Load() != 1 {
+		t.Fatalf("queued frame handled %d times once a slot freed, want 1", handled.Load())
+	}
+
+	// Otherwise it is dropped once the wait expires.
+	busyDone = hold()
+	queuedDone = make(chan struct{})
+	go func() {
+		defer close(queuedDone)
+		enginetest.Traffic(e, queued, frame(2, ""))
+	}()
+	for waiting := true; waiting; {
+		select {
+		case <-queuedDone:
+			waiting = false
+		case <-time.After(time.Millisecond):
+			clk.Advance(e.HandlerQueueWait)
+		}
+	}
+	if handled.Load() != 1 {
+		t.Fatalf("queued frame handled after the wait expired, want it dropped")
+	}
+	release <- struct{}{}
+	<-busyDone
+}
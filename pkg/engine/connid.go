@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// ConnIDFunc generates the ID assigned to a connection in OnOpen. It may be
+// called concurrently from several event loops.
+type ConnIDFunc func() string
+
+// UUIDConnID generates random (version 4) UUIDs, for IDs that stay unique
+// across processes and restarts.
+func UUIDConnID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newConnID returns the ID for a new connection: ConnIDGenerator's if set,
+// otherwise a per-engine monotonic counter starting at 1.
+func (e *EngineWrapper[T]) newConnID() string {
+	if e.ConnIDGenerator != nil {
+		return e.ConnIDGenerator()
+	}
+	return strconv.FormatUint(e.connSeq.Add(1), 10)
+}
+
+// ConnID returns the ID assigned to c when it was opened, or "" if c is not
+// an open connection (e.g. UDP). It is safe to call from any goroutine.
+func (e *EngineWrapper[T]) ConnID(c gnet.Conn) string {
+	st := e.registry.state(c)
+	if st == nil {
+		return ""
+	}
+	return st.id
+}
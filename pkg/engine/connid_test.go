@@ -0,0 +1,62 @@
+package engine_test
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+)
+
+func TestConnIDsUnique(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+
+	const n = 200
+	conns := make([]*enginetest.Conn, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		conns[i] = enginetest.NewConn()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.OnOpen(conns[i])
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, n)
+	for _, c := range conns {
+		id := e.ConnID(c)
+		if id == "" || seen[id] {
+			t.Fatalf("ConnID = %q, want a unique non-empty ID", id)
+		}
+		seen[id] = true
+	}
+
+	e.OnClose(conns[0], nil)
+	if id := e.ConnID(conns[0]); id != "" {
+		t.Fatalf("ConnID = %q after OnClose, want \"\"", id)
+	}
+}
+
+func TestConnIDGenerator(t *testing.T) {
+	logs := captureLog(t)
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.ConnIDGenerator = engine.UUIDConnID
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	id := e.ConnID(c)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(id) {
+		t.Fatalf("ConnID = %q, want a version 4 UUID", id)
+	}
+
+	enginetest.Traffic(e, c, frame(9, "unrouted"))
+	if !strings.Contains(logs.String(), `"conn":"`+id+`"`) {
+		t.Fatalf("warning does not carry the connection ID %s:\n%s", id, logs)
+	}
+}
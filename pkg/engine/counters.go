@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"cmp"
+	"slices"
+)
+
+// RouteCount is the number of messages dispatched to one route. Matcher
+// routes are reported under their Matcher ID with every message they matched.
+type RouteCount struct {
+	ID    int
+	Name  string
+	Count uint64
+}
+
+// ResetCounters zeroes the invocation counter of every registered route.
+// It is safe to call while the engine is serving traffic.
+func (e *EngineWrapper[T]) ResetCounters() {
+	e.SetRoutes(e.Routes())
+}
+
+// TopRoutes returns the n most invoked routes since the counters were
+// reset, busiest first. Ties are ordered by ID. A negative n returns all.
+func (e *EngineWrapper[T]) TopRoutes(n int) []RouteCount {
+	t := e.table()
+
+	counts := make([]RouteCount, 0, len(t.counters)+len(t.matched))
+	for id, cnt := range t.counters {
+		counts = append(counts, RouteCount{ID: id, Name: t.Names[id], Count: cnt.Load()})
+	}
+	for i, cnt := range t.matched {
+		m := t.Matchers[i]
+		counts = append(counts, RouteCount{ID: m.ID, Name: m.Name, Count: cnt.Load()})
+	}
+
+	slices.SortStableFunc(counts, func(a, b RouteCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	if n >= 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
@@ -0,0 +1,50 @@
+package engine_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+)
+
+func TestTopRoutesRanking(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.SetRoutes(engine.RouteTable{
+		Handlers: map[int]handler.HandlerFunc{1: echo(), 2: echo(), 3: echo(), 4: echo()},
+		Names:    map[int]string{1: "Login", 2: "Move", 3: "Chat", 4: "Logout"},
+	})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	for id, n := range map[byte]int{1: 1, 2: 5, 3: 2, 4: 2, 9: 7} {
+		for range n {
+			enginetest.Traffic(e, c, frame(id, ""))
+		}
+	}
+
+	// Ties are broken by ID; unrouted messages are not counted.
+	want := []engine.RouteCount{
+		{ID: 2, Name: "Move", Count: 5},
+		{ID: 3, Name: "Chat", Count: 2},
+		{ID: 4, Name: "Logout", Count: 2},
+		{ID: 1, Name: "Login", Count: 1},
+	}
+	if got := e.TopRoutes(-1); !slices.Equal(got, want) {
+		t.Fatalf("TopRoutes(-1) = %+v, want %+v", got, want)
+	}
+	if got := e.TopRoutes(2); !slices.Equal(got, want[:2]) {
+		t.Fatalf("TopRoutes(2) = %+v, want %+v", got, want[:2])
+	}
+	if got := e.TopRoutes(10); len(got) != 4 {
+		t.Fatalf("TopRoutes(10) = %d routes, want all 4", len(got))
+	}
+
+	e.ResetCounters()
+	for _, rc := range e.TopRoutes(-1) {
+		if rc.Count != 0 {
+			t.Fatalf("%s counted %d after ResetCounters, want 0", rc.Name, rc.Count)
+		}
+	}
+}
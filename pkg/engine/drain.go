@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Drain sends packet to every open connection and closes each one once its
+// write has completed, so peers receive the packet before the close. It
+// waits until every connection has been handled or ctx is done.
+//
+// It is safe to call from any goroutine, and is meant for use just before
+// stopping the transport.
+func (e *EngineWrapper[T]) Drain(ctx context.Context, packet []byte) error {
+	var wg sync.WaitGroup
+	for c := range e.registry.snapshot() {
+		e.capture(c, Outbound, -1, packet)
+
+		wg.Add(1)
+		err := c.AsyncWrite(packet, func(c gnet.Conn, _ error) error {
+			defer wg.Done()
+			return c.Close()
+		})
+		if err != nil {
+			wg.Done()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Drain: %w", ctx.Err())
+	}
+}
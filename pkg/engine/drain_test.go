@@ -0,0 +1,34 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// stalledConn never completes its queued writes, as a peer that stopped
+// reading does.
+type stalledConn struct{ *enginetest.Conn }
+
+func (stalledConn) AsyncWrite([]byte, gnet.AsyncCallback) error { return nil }
+
+func TestDrainGivesUpWithContext(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	stalled, prompt := stalledConn{enginetest.NewConn()}, enginetest.NewConn()
+	e.OnOpen(stalled)
+	e.OnOpen(prompt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := e.Drain(ctx, frame(0, "bye")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain = %v, want context.DeadlineExceeded", err)
+	}
+	if !prompt.Closed() || string(prompt.Written()) != string(frame(0, "bye")) {
+		t.Fatal("connection with a completed write was not sent the frame and closed")
+	}
+}
@@ -1,19 +1,34 @@
 package engine
 
 import (
+	"context"
+	"io"
+	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/etwodev/bmux/internal/logwriter"
+	"github.com/etwodev/bmux/pkg/clock"
 	"github.com/etwodev/bmux/pkg/handler"
 	"github.com/panjf2000/gnet/v2"
 	"github.com/rs/zerolog"
 )
 
-var log = zerolog.New(zerolog.ConsoleWriter{
+// logOut is the sink of the package logger, swapped by SetLogWriter.
+var logOut = logwriter.New(zerolog.ConsoleWriter{
 	Out:        os.Stdout,
 	TimeFormat: "2006-01-02T15:04:05",
-}).With().Timestamp().Str("Group", "bmux-engine").Logger()
+})
+
+var log = zerolog.New(logOut).With().Timestamp().Str("Group", "bmux-engine").Logger()
+
+// SetLogWriter redirects the package logger to w. It is safe to call while
+// the server is running: each log line goes entirely to the old or new w.
+func SetLogWriter(w io.Writer) {
+	logOut.Store(w)
+}
 
 type ExtractLengthFunc[T any] func(c gnet.Conn, buf []byte) (headLen int, totalLen int)
 type ExtractMsgIDFunc[T any] func(c gnet.Conn, head []byte, body []byte) (msgID int)
@@ -21,76 +36,282 @@ type ContextFactoryFunc[T any] func() *T
 
 type EngineWrapper[T any] struct {
 	gnet.BuiltinEventEngine
-	Engine            gnet.Engine
-	ContextFactory    ContextFactoryFunc[T]
-	ExtractLength     ExtractLengthFunc[T]
-	ExtractMsgID      ExtractMsgIDFunc[T]
-	LastIdleReset     time.Time
-	ActiveConnections int64
-	MaxConnections    int64
-	HeadSize          int
-	Handlers          map[int]handler.HandlerFunc
+	Engine                gnet.Engine
+	ContextFactory        ContextFactoryFunc[T]
+	ExtractLength         ExtractLengthFunc[T]
+	ExtractMsgID          ExtractMsgIDFunc[T]
+	LastIdleReset         time.Time
+	ActiveConnections     int64
+	MaxConnections        int64
+	MaxWriteFailures      int64
+	LogHandlerCloses      bool
+	ProtocolMagic         []byte
+	OnConnectionLimit     func(c gnet.Conn)
+	IdleTimeout           time.Duration
+	IdleWarningTimeout    time.Duration
+	OnIdleWarning         func(c gnet.Conn)
+	WriteCoalesceWindow   time.Duration
+	WriteCoalesceBytes    int
+	Nack                  func(msgID int) []byte
+	HeadSize              int
+	routes                atomic.Pointer[routeTable]
+	routesMu              sync.Mutex
+	registry              registry
+	latency               *latencyRecorder
+	booted                atomic.Bool
+	bootMu                sync.Mutex
+	bootC                 chan struct{} // closed on boot, replaced on shutdown
+	MaxFramesPerSecond    int
+	CloseOnFrameLimit     bool
+	WarmupReply           []byte
+	warming               atomic.Bool
+	ConnIDGenerator       ConnIDFunc
+	connSeq               atomic.Uint64
+	Capture               *Capture
+	OnConnect             []func(c gnet.Conn) error
+	MaxConcurrentHandlers int
+	HandlerQueueWait      time.Duration
+	handlerSlots          chan struct{}
+	handlerSlotsOnce      sync.Once
+	MaxAcceptsPerSecond   int
+	AcceptBurst           int
+	accepts               tokenBucket
+	MaxPendingInbound     int
+	ExtractSequence       ExtractSequenceFunc[T]
+	ReplayWindow          int
+	WarnLogBurst          int
+	WarnLogSample         int
+	WarnLogWindow         time.Duration
+	EnableSOLinger        bool
+	SOLinger              int
+	Framer                Framer
+	Clock                 clock.Clock
 }
 
 func (e *EngineWrapper[T]) OnBoot(eng gnet.Engine) gnet.Action {
 	e.Engine = eng
+	e.booted.Store(true)
+
+	e.bootMu.Lock()
+	if e.bootC == nil {
+		e.bootC = make(chan struct{})
+	}
+	select {
+	case <-e.bootC:
+	default:
+		close(e.bootC)
+	}
+	e.bootMu.Unlock()
+
 	return gnet.None
 }
 
+func (e *EngineWrapper[T]) OnShutdown(eng gnet.Engine) {
+	e.booted.Store(false)
+
+	e.bootMu.Lock()
+	e.bootC = nil
+	e.bootMu.Unlock()
+}
+
+// Booted reports whether the engine is running and accepting connections.
+func (e *EngineWrapper[T]) Booted() bool {
+	return e.booted.Load()
+}
+
+// WaitBooted blocks until the engine has booted or ctx is done, returning
+// ctx.Err() in the latter case.
+func (e *EngineWrapper[T]) WaitBooted(ctx context.Context) error {
+	e.bootMu.Lock()
+	if e.bootC == nil {
+		e.bootC = make(chan struct{})
+	}
+	booted := e.bootC
+	e.bootMu.Unlock()
+
+	select {
+	case <-booted:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (e *EngineWrapper[T]) OnOpen(c gnet.Conn) ([]byte, gnet.Action) {
+	if !e.Ready() {
+		return e.refuseWarming(c)
+	}
 	if atomic.LoadInt64(&e.ActiveConnections) >= e.MaxConnections {
+		if e.OnConnectionLimit != nil {
+			e.OnConnectionLimit(c)
+		}
+		return nil, gnet.Close
+	}
+	if !e.admit(c) {
 		return nil, gnet.Close
 	}
 	atomic.AddInt64(&e.ActiveConnections, 1)
 	c.SetContext(e.ContextFactory())
+
+	if e.EnableSOLinger {
+		if err := c.SetLinger(e.SOLinger); err != nil {
+			log.Debug().Err(err).Int("secs", e.SOLinger).Msg("failed to set SO_LINGER")
+		}
+	}
+	e.registry.add(c, e.newConnID(), e.now())
+
+	for _, fn := range e.OnConnect {
+		if err := fn(c); err != nil {
+			log.Debug().
+				Err(err).
+				Str("remote", c.RemoteAddr().String()).
+				Str("conn", e.ConnID(c)).
+				Msg("connection rejected by connect hook")
+
+			return nil, gnet.Close
+		}
+	}
 	return nil, gnet.None
 }
 
+// OnClose releases the slot of an accepted connection. gnet also fires it
+// for connections refused in OnOpen, which never took a slot.
 func (e *EngineWrapper[T]) OnClose(c gnet.Conn, err error) gnet.Action {
-	atomic.AddInt64(&e.ActiveConnections, -1)
+	if e.registry.remove(c) {
+		atomic.AddInt64(&e.ActiveConnections, -1)
+	}
 	return gnet.None
 }
 
+// OnTraffic dispatches every complete frame buffered on c. A frame whose
+// bytes have not all arrived yet stays buffered, untouched, until a later
+// event completes it; MaxPendingInbound bounds how much may wait.
 func (e *EngineWrapper[T]) OnTraffic(c gnet.Conn) gnet.Action {
+	if _, udp := c.LocalAddr().(*net.UDPAddr); udp {
+		// UDP is connectionless: gnet never fires OnOpen/OnClose, so every
+		// datagram gets a fresh context that does not persist.
+		c.SetContext(e.ContextFactory())
+	}
+
+	e.touch(c)
+
+	if ok, act := e.verifyMagic(c); !ok {
+		return act
+	}
+
+	for {
+		action, more := e.dispatch(c)
+		if action != gnet.None || !more {
+			return action
+		}
+	}
+}
+
+// dispatch reads a single frame from c and runs its handler. It reports
+// the resulting action and whether another frame may be buffered.
+func (e *EngineWrapper[T]) dispatch(c gnet.Conn) (gnet.Action, bool) {
 	var h handler.HandlerFunc
-	var buf []byte
-	var err error
+	var frame []byte
+	var head, body []byte
 	var ok bool
-	var ttl int
-	var hd int
+	var id int
+	var start time.Time
+	var action gnet.Action
+	var release func()
 
-	buf, err = c.Next(e.HeadSize)
-	if err != nil {
-		log.Warn().
-			Err(err).
-			Str("remote", c.RemoteAddr().String()).
-			Msg("failed to read header from connection")
+	if e.Framer != nil {
+		frame, head, body, action, ok = e.readFramed(c)
+	} else {
+		frame, head, body, action, ok = e.readLengthPrefixed(c)
+	}
+	if !ok {
+		return action, false
+	}
 
-		goto respond
+	// Empty parts are valid: the header is then nil and the body empty but
+	// non-nil, so handlers never need to special-case them.
+	if len(head) == 0 {
+		head = nil
+	}
+	if len(body) == 0 {
+		body = []byte{}
 	}
 
-	hd, ttl = e.ExtractLength(c, buf)
-	buf, err = c.Next(ttl)
-	if err != nil {
-		log.Warn().
-			Err(err).
-			Str("remote", c.RemoteAddr().String()).
-			Int("expected", ttl).
-			Msg("failed to read full payload from connection")
+	if ok, act := e.throttle(c); !ok {
+		return act, true
+	}
 
-		goto respond
+	id = e.ExtractMsgID(c, head, body)
+	e.capture(c, Inbound, id, frame)
+
+	if !e.checkReplay(c, head, body) {
+		return gnet.None, true
 	}
 
-	h, ok = e.Handlers[e.ExtractMsgID(c, buf[:hd], buf[hd:])]
+	routes := e.table()
+	h, count, key, ok := routes.resolve(id)
 	if !ok {
-		log.Warn().
+		e.frameWarn(c).
 			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("msgID", id).
+			Str("route", routes.name(id)).
 			Msg("no handler registered for message")
 
-		goto respond
+		if e.Nack != nil {
+			nack := e.Nack(id)
+			e.capture(c, Outbound, id, nack)
+			if _, err := c.Write(nack); err != nil {
+				return gnet.Close, false
+			}
+		}
+
+		return gnet.None, true
 	}
 
-	return h(c, buf[hd:])
-respond:
-	return gnet.None
+	release, ok = e.acquireHandler(c, id)
+	if !ok {
+		return gnet.None, true
+	}
+	defer release()
+
+	count.Add(1)
+
+	if e.latency != nil {
+		start = e.now()
+	}
+
+	action = h(c, body)
+
+	if e.latency != nil {
+		samplesFor(&e.latency.byRoute, key).record(e.now().Sub(start))
+	}
+
+	if action == gnet.Close && e.LogHandlerCloses {
+		log.Debug().
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("msgID", id).
+			Str("route", routes.name(id)).
+			Msg("handler closed connection")
+	}
+
+	return action, true
+}
+
+// pending enforces MaxPendingInbound on the buffered bytes of c that do not
+// yet form a complete frame.
+func (e *EngineWrapper[T]) pending(c gnet.Conn, buffered int) gnet.Action {
+	if e.MaxPendingInbound <= 0 || buffered <= e.MaxPendingInbound {
+		return gnet.None
+	}
+
+	e.frameWarn(c).
+		Str("remote", c.RemoteAddr().String()).
+		Str("conn", e.ConnID(c)).
+		Int("buffered", buffered).
+		Int("limit", e.MaxPendingInbound).
+		Msg("incomplete frame exceeds pending inbound limit, closing connection")
+
+	return gnet.Close
 }
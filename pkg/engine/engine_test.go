@@ -0,0 +1,229 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/rs/zerolog"
+)
+
+type testContext struct{}
+
+func newContext() *testContext { return &testContext{} }
+
+// extractLength reads a 1 byte head length and a 2 byte body length.
+func extractLength(_ gnet.Conn, buf []byte) (headLen, totalLen int) {
+	hd := int(buf[0])
+	return hd, hd + int(binary.LittleEndian.Uint16(buf[1:3]))
+}
+
+// extractMsgID reads the message ID from the first head byte.
+func extractMsgID(_ gnet.Conn, head, _ []byte) int {
+	if len(head) == 0 {
+		return -1
+	}
+	return int(head[0])
+}
+
+// frame builds a frame with a single byte head holding id.
+func frame(id byte, body string) []byte {
+	buf := []byte{1, 0, 0, id}
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(len(body)))
+	return append(buf, body...)
+}
+
+func echo() handler.HandlerFunc {
+	return func(c gnet.Conn, body []byte) gnet.Action {
+		_, _ = c.Write(frame(0, string(body)))
+		return gnet.None
+	}
+}
+
+// captureLog redirects the engine logger to a buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	engine.SetLogWriter(&buf)
+	t.Cleanup(func() { engine.SetLogWriter(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "2006-01-02T15:04:05"}) })
+	return &buf
+}
+
+func TestSOLingerLeftAloneByDefault(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	if secs, ok := c.Linger(); ok {
+		t.Fatalf("SetLinger(%d) called without EnableSOLinger", secs)
+	}
+}
+
+func TestSOLingerApplied(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.EnableSOLinger = true
+	e.SOLinger = 0
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	if secs, ok := c.Linger(); !ok || secs != 0 {
+		t.Fatalf("Linger() = %d, %v, want 0, true", secs, ok)
+	}
+}
+
+func TestZeroLengthFramesClose(t *testing.T) {
+	// Without a fixed header the extractor sees every buffered byte.
+	e := enginetest.New(newContext,
+		func(_ gnet.Conn, buf []byte) (int, int) { return 0, int(buf[0]) },
+		func(gnet.Conn, []byte, []byte) int { return 0 }, 0,
+		map[int]handler.HandlerFunc{0: echo()})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	if action := e.OnTraffic(c); action != gnet.None {
+		t.Fatalf("OnTraffic on an empty buffer = %v, want gnet.None", action)
+	}
+	if action := enginetest.Traffic(e, c, []byte{0}); action != gnet.Close {
+		t.Fatalf("OnTraffic on a zero-length frame = %v, want gnet.Close", action)
+	}
+}
+
+// udpConn is a Conn whose local address is a UDP socket, as gnet presents
+// datagrams.
+type udpConn struct{ *enginetest.Conn }
+
+func (udpConn) LocalAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 30001} }
+
+func TestUDPDatagramsDispatched(t *testing.T) {
+	var contexts []*session
+	e := enginetest.New(func() *session { return &session{} }, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: func(c gnet.Conn, body []byte) gnet.Action {
+			contexts = append(contexts, c.Context().(*session))
+			return echo()(c, body)
+		}})
+	e.ProtocolMagic = []byte("BMUX")
+
+	// gnet never fires OnOpen for UDP, so the datagram arrives unannounced.
+	c := udpConn{enginetest.NewConn()}
+	for _, body := range []string{"one", "two"} {
+		c.Feed(frame(1, body))
+		if action := e.OnTraffic(c); action != gnet.None {
+			t.Fatalf("OnTraffic on a datagram = %v, want gnet.None", action)
+		}
+	}
+
+	want := append(frame(0, "one"), frame(0, "two")...)
+	if got := c.Written(); string(got) != string(want) {
+		t.Fatalf("Written = %q, want %q", got, want)
+	}
+	if len(contexts) != 2 || contexts[0] == contexts[1] {
+		t.Fatal("datagrams shared a context, want a fresh one per datagram")
+	}
+}
+
+func TestHandlerClosesLogged(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		logs := captureLog(t)
+		e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+			map[int]handler.HandlerFunc{1: func(gnet.Conn, []byte) gnet.Action { return gnet.Close }})
+		e.LogHandlerCloses = enabled
+		c := enginetest.NewConn()
+		e.OnOpen(c)
+
+		if action := enginetest.Traffic(e, c, frame(1, "bye")); action != gnet.Close {
+			t.Fatalf("OnTraffic = %v, want the handler's gnet.Close", action)
+		}
+
+		logged := strings.Contains(logs.String(), "handler closed connection")
+		if logged != enabled {
+			t.Errorf("LogHandlerCloses %v: close logged = %v", enabled, logged)
+		}
+		if enabled && !strings.Contains(logs.String(), `"msgID":1`) {
+			t.Errorf("close log misses the message ID:\n%s", logs)
+		}
+	}
+}
+
+func TestOnConnectionLimit(t *testing.T) {
+	var limited []gnet.Conn
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.MaxConnections = 1
+	e.OnConnectionLimit = func(c gnet.Conn) {
+		_, _ = c.Write([]byte("server full"))
+		limited = append(limited, c)
+	}
+
+	first, second := enginetest.NewConn(), enginetest.NewConn()
+	if _, action := e.OnOpen(first); action != gnet.None {
+		t.Fatalf("OnOpen under the limit = %v, want gnet.None", action)
+	}
+	if _, action := e.OnOpen(second); action != gnet.Close {
+		t.Fatalf("OnOpen over the limit = %v, want gnet.Close", action)
+	}
+	e.OnClose(second, nil)
+
+	if len(limited) != 1 || limited[0] != second {
+		t.Fatalf("OnConnectionLimit called with %v, want only the refused connection", limited)
+	}
+	if got := string(second.Written()); got != "server full" {
+		t.Fatalf("refused connection received %q, want the callback's reply", got)
+	}
+
+	e.OnClose(first, nil)
+	if _, action := e.OnOpen(enginetest.NewConn()); action != gnet.None {
+		t.Fatalf("OnOpen after a slot was released = %v, want gnet.None", action)
+	}
+	if len(limited) != 1 {
+		t.Fatalf("OnConnectionLimit called %d times, want 1", len(limited))
+	}
+}
+
+func TestMaxFramesPerSecond(t *testing.T) {
+	for _, closeOnLimit := range []bool{false, true} {
+		clk := clock.NewFake(time.Unix(0, 0))
+		handled := 0
+		e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+			map[int]handler.HandlerFunc{1: func(gnet.Conn, []byte) gnet.Action {
+				handled++
+				return gnet.None
+			}})
+		e.Clock = clk
+		e.MaxFramesPerSecond = 5
+		e.CloseOnFrameLimit = closeOnLimit
+
+		c := enginetest.NewConn()
+		e.OnOpen(c)
+
+		var action gnet.Action
+		for range 8 {
+			if action = enginetest.Traffic(e, c, frame(1, "")); action != gnet.None {
+				break
+			}
+		}
+		if handled != 5 {
+			t.Fatalf("CloseOnFrameLimit %v: %d frames handled in one second, want 5", closeOnLimit, handled)
+		}
+		if closeOnLimit {
+			if action != gnet.Close {
+				t.Fatalf("OnTraffic over the limit = %v, want gnet.Close", action)
+			}
+			continue
+		}
+
+		// Frames over the limit are dropped; the budget recovers as the
+		// window slides.
+		clk.Advance(2 * time.Second)
+		enginetest.Traffic(e, c, frame(1, ""))
+		if handled != 6 {
+			t.Fatalf("%d frames handled after the window passed, want 6", handled)
+		}
+	}
+}
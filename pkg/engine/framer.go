@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"math"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Framer finds frame boundaries in the inbound stream, replacing the
+// default length-prefix framing (HeadSize and ExtractLength), e.g. for
+// newline-delimited protocols.
+type Framer interface {
+	// NextFrame returns the length of the first frame at the start of
+	// buffered, or ok false if buffered does not hold a whole frame yet.
+	// A frameLen of 0 or less with ok true marks the stream as malformed
+	// and closes the connection.
+	NextFrame(buffered []byte) (frameLen int, ok bool)
+}
+
+// FrameSplitter is optionally implemented by a Framer to split a frame
+// into the header and body passed to ExtractMsgID and handlers. Without
+// it a frame is all body.
+type FrameSplitter interface {
+	Split(frame []byte) (head, body []byte)
+}
+
+// readLengthPrefixed reads the next frame using HeadSize and ExtractLength.
+// It returns ok false with the action to take when no complete frame can
+// be read.
+func (e *EngineWrapper[T]) readLengthPrefixed(c gnet.Conn) (frame, head, body []byte, action gnet.Action, ok bool) {
+	buffered := c.InboundBuffered()
+	if buffered == 0 || buffered < e.HeadSize {
+		return nil, nil, nil, e.pending(c, buffered), false
+	}
+
+	prefix, err := c.Peek(e.HeadSize)
+	if err != nil {
+		return nil, nil, nil, gnet.None, false
+	}
+
+	hd, ttl := e.ExtractLength(c, prefix)
+	if ttl < 0 || hd < 0 || hd > ttl || ttl > math.MaxInt-e.HeadSize || e.HeadSize+ttl == 0 {
+		// An inverted head length would slice out of range, a length
+		// overflowing with the prefix would wrap negative, and a frame of
+		// no bytes at all would never advance, so the framing cannot be
+		// trusted any further.
+		e.frameWarn(c).
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("headLen", hd).
+			Int("totalLen", ttl).
+			Msg("invalid frame lengths from extractor, closing connection")
+
+		return nil, nil, nil, gnet.Close, false
+	}
+
+	if buffered < e.HeadSize+ttl {
+		return nil, nil, nil, e.pending(c, buffered), false
+	}
+
+	frame, err = c.Next(e.HeadSize + ttl)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("expected", e.HeadSize+ttl).
+			Msg("failed to read frame from connection")
+
+		return nil, nil, nil, gnet.Close, false
+	}
+
+	return frame, frame[e.HeadSize : e.HeadSize+hd], frame[e.HeadSize+hd:], gnet.None, true
+}
+
+// readFramed reads the next frame using Framer.
+func (e *EngineWrapper[T]) readFramed(c gnet.Conn) (frame, head, body []byte, action gnet.Action, ok bool) {
+	buffered := c.InboundBuffered()
+	if buffered == 0 {
+		return nil, nil, nil, gnet.None, false
+	}
+
+	buf, err := c.Peek(buffered)
+	if err != nil {
+		return nil, nil, nil, gnet.None, false
+	}
+
+	n, ok := e.Framer.NextFrame(buf)
+	if !ok {
+		return nil, nil, nil, e.pending(c, buffered), false
+	}
+
+	if n <= 0 || n > buffered {
+		e.frameWarn(c).
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("frameLen", n).
+			Int("buffered", buffered).
+			Msg("invalid frame length from framer, closing connection")
+
+		return nil, nil, nil, gnet.Close, false
+	}
+
+	frame, err = c.Next(n)
+	if err != nil {
+		return nil, nil, nil, gnet.Close, false
+	}
+
+	if splitter, ok := e.Framer.(FrameSplitter); ok {
+		head, body = splitter.Split(frame)
+		return frame, head, body, gnet.None, true
+	}
+	return frame, nil, frame, gnet.None, true
+}
@@ -0,0 +1,115 @@
+package engine_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestInvalidFrameLengthsClose(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		hd, ttl int
+	}{
+		{"negative total length", 0, -1},
+		{"negative head length", -1, 4},
+		{"head longer than frame", 5, 4},
+		{"total length overflowing the prefix", 0, math.MaxInt - 1},
+		{"head and total at MaxInt", math.MaxInt, math.MaxInt},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := enginetest.New(newContext,
+				func(gnet.Conn, []byte) (int, int) { return tc.hd, tc.ttl },
+				extractMsgID, 3, map[int]handler.HandlerFunc{1: echo()})
+			c := enginetest.NewConn()
+			e.OnOpen(c)
+
+			if action := enginetest.Traffic(e, c, frame(1, "body")); action != gnet.Close {
+				t.Fatalf("OnTraffic = %v, want gnet.Close", action)
+			}
+			if out := c.Written(); len(out) != 0 {
+				t.Fatalf("handler ran for an invalid frame, wrote %q", out)
+			}
+		})
+	}
+}
+
+func TestFrameArrivingInPieces(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	packet := frame(1, "hello")
+	for _, part := range [][]byte{packet[:2], packet[2:5], packet[5:]} {
+		if action := enginetest.Traffic(e, c, part); action != gnet.None {
+			t.Fatalf("OnTraffic = %v, want gnet.None", action)
+		}
+	}
+	if got, want := string(c.Written()), string(frame(0, "hello")); got != want {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+}
+
+func TestEmptyHeadOrBodyDispatched(t *testing.T) {
+	type seen struct{ id, head, body int }
+	var got []seen
+	var head int
+	record := func(id int) handler.HandlerFunc {
+		return func(_ gnet.Conn, body []byte) gnet.Action {
+			got = append(got, seen{id, head, len(body)})
+			return gnet.None
+		}
+	}
+	e := enginetest.New(newContext, extractLength,
+		func(c gnet.Conn, h, body []byte) int {
+			head = len(h)
+			return extractMsgID(c, h, body)
+		}, 3, map[int]handler.HandlerFunc{1: record(1), -1: record(-1)})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	for _, f := range [][]byte{
+		frame(1, ""),                  // head only
+		{0, 4, 0, 'b', 'o', 'd', 'y'}, // body only
+		{0, 0, 0},                     // prefix only
+	} {
+		if action := enginetest.Traffic(e, c, f); action != gnet.None {
+			t.Fatalf("OnTraffic(%v) = %v, want gnet.None", f, action)
+		}
+	}
+
+	want := []seen{{1, 1, 0}, {-1, 0, 4}, {-1, 0, 0}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("dispatched %v, want %v", got, want)
+	}
+	if n := c.InboundBuffered(); n != 0 {
+		t.Fatalf("%d bytes left buffered, want every frame consumed", n)
+	}
+}
+
+func TestMaxPendingInbound(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	e.MaxPendingInbound = 8
+
+	// A frame arriving whole is dispatched whatever its size.
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+	if action := enginetest.Traffic(e, c, frame(1, "a body well past the limit")); action != gnet.None {
+		t.Fatalf("OnTraffic on a complete frame = %v, want gnet.None", action)
+	}
+
+	// An incomplete one may only buffer up to the limit.
+	f := frame(1, "a body well past the limit")
+	if action := enginetest.Traffic(e, c, f[:8]); action != gnet.None {
+		t.Fatalf("OnTraffic with 8 bytes pending = %v, want gnet.None", action)
+	}
+	if action := enginetest.Traffic(e, c, f[8:9]); action != gnet.Close {
+		t.Fatalf("OnTraffic with 9 bytes pending = %v, want gnet.Close", action)
+	}
+}
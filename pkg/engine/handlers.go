@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"maps"
+	"sync/atomic"
+
+	"github.com/etwodev/bmux/pkg/handler"
+)
+
+// Route table
+//
+// OnTraffic resolves routes on every frame from every event loop, while
+// routes may be registered at any time. The table is therefore never
+// mutated in place: readers load the current snapshot through an atomic
+// pointer without locking, and writers publish a modified copy. Handlers,
+// matchers, names and counters live in one snapshot, so a frame never sees
+// the handlers of one registration with the names of another.
+
+// RouteTable is the set of routes the engine dispatches to.
+type RouteTable struct {
+	Handlers map[int]handler.HandlerFunc // Handlers by exact message ID
+	Matchers []Matcher                   // Consulted in order for IDs without a handler
+	Names    map[int]string              // Route names of Handlers, for logging
+	Keys     map[int]int                 // Latency key of Handlers IDs served by a multi-ID route; others are their own key
+}
+
+// routeTable is a published RouteTable with its invocation counters.
+type routeTable struct {
+	RouteTable
+	counters map[int]*atomic.Uint64 // by message ID, one per handler
+	matched  []*atomic.Uint64       // by index, one per matcher
+}
+
+func newRouteTable(rt RouteTable) *routeTable {
+	t := &routeTable{
+		RouteTable: rt,
+		counters:   make(map[int]*atomic.Uint64, len(rt.Handlers)),
+		matched:    make([]*atomic.Uint64, len(rt.Matchers)),
+	}
+	for id := range rt.Handlers {
+		t.counters[id] = &atomic.Uint64{}
+	}
+	for i := range rt.Matchers {
+		t.matched[i] = &atomic.Uint64{}
+	}
+	return t
+}
+
+// resolve returns the handler of id with its counter and latency key,
+// trying the exact handlers first and the matchers after.
+func (t *routeTable) resolve(id int) (h handler.HandlerFunc, count *atomic.Uint64, key int, ok bool) {
+	if h, ok := t.Handlers[id]; ok {
+		if key, ok := t.Keys[id]; ok {
+			return h, t.counters[id], key, true
+		}
+		return h, t.counters[id], id, true
+	}
+	for i, m := range t.Matchers {
+		if m.Match(id) {
+			return m.Handler, t.matched[i], m.ID, true
+		}
+	}
+	return nil, nil, 0, false
+}
+
+// name returns the name of the route handling id for logging, or
+// "unknown" if no route does.
+func (t *routeTable) name(id int) string {
+	if name, ok := t.Names[id]; ok {
+		return name
+	}
+	for _, m := range t.Matchers {
+		if m.Match(id) {
+			return m.Name
+		}
+	}
+	return "unknown"
+}
+
+// table returns the current route table, empty before any is published.
+func (e *EngineWrapper[T]) table() *routeTable {
+	if t := e.routes.Load(); t != nil {
+		return t
+	}
+	return &routeTable{}
+}
+
+// Routes returns the current route table. Its maps and slices are shared
+// and must not be modified; use SetRoutes, SetHandlers or Handle instead.
+func (e *EngineWrapper[T]) Routes() RouteTable {
+	return e.table().RouteTable
+}
+
+// Handlers returns the current message ID to handler table. The map is
+// shared and must not be modified; use SetHandlers or Handle instead.
+func (e *EngineWrapper[T]) Handlers() map[int]handler.HandlerFunc {
+	return e.table().Handlers
+}
+
+// SetRoutes replaces the route table with rt, which the engine takes
+// ownership of, and resets the route counters to match it. It is safe to
+// call while the engine is serving traffic.
+func (e *EngineWrapper[T]) SetRoutes(rt RouteTable) {
+	e.routesMu.Lock()
+	defer e.routesMu.Unlock()
+
+	e.routes.Store(newRouteTable(rt))
+}
+
+// SetHandlers replaces the exact handlers with handlers, which the engine
+// takes ownership of, keeping the matchers and route names. Like SetRoutes
+// it resets the route counters.
+func (e *EngineWrapper[T]) SetHandlers(handlers map[int]handler.HandlerFunc) {
+	e.routesMu.Lock()
+	defer e.routesMu.Unlock()
+
+	rt := e.table().RouteTable
+	rt.Handlers = handlers
+	e.routes.Store(newRouteTable(rt))
+}
+
+// Handle registers h for id, replacing any previous handler, without
+// disturbing the other routes or their counters. It is safe to call while
+// the engine is serving traffic.
+func (e *EngineWrapper[T]) Handle(id int, h handler.HandlerFunc) {
+	e.routesMu.Lock()
+	defer e.routesMu.Unlock()
+
+	cur := e.table()
+	next := &routeTable{
+		RouteTable: cur.RouteTable,
+		counters:   maps.Clone(cur.counters),
+		matched:    cur.matched,
+	}
+
+	next.Handlers = maps.Clone(cur.Handlers)
+	if next.Handlers == nil {
+		next.Handlers = make(map[int]handler.HandlerFunc)
+	}
+	next.Handlers[id] = h
+
+	if next.counters == nil {
+		next.counters = make(map[int]*atomic.Uint64)
+	}
+	if _, ok := next.counters[id]; !ok {
+		next.counters[id] = &atomic.Uint64{}
+	}
+
+	e.routes.Store(next)
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// idleTickInterval is how often OnTick scans for idle connections.
+const idleTickInterval = time.Second
+
+// touch records activity on c, resetting its idle period.
+func (e *EngineWrapper[T]) touch(c gnet.Conn) {
+	if e.IdleTimeout <= 0 {
+		return
+	}
+
+	if st := e.registry.state(c); st != nil {
+		st.lastActive.Store(e.now().UnixNano())
+		st.idleWarned.Store(false)
+	}
+}
+
+// OnTick closes connections idle for IdleTimeout. When IdleWarningTimeout
+// is set, OnIdleWarning fires once per idle period beforehand, giving the
+// peer a chance to send a keepalive.
+//
+// OnTick runs on gnet's ticker goroutine rather than the connection's
+// event loop, so OnIdleWarning must only use the concurrency-safe
+// gnet.Conn methods, e.g. AsyncWrite.
+func (e *EngineWrapper[T]) OnTick() (time.Duration, gnet.Action) {
+	if e.IdleTimeout <= 0 {
+		return idleTickInterval, gnet.None
+	}
+
+	now := e.now()
+	for c, st := range e.registry.snapshot() {
+		idle := now.Sub(time.Unix(0, st.lastActive.Load()))
+
+		if idle >= e.IdleTimeout {
+			log.Debug().
+				Str("conn", st.id).
+				Dur("idle", idle).
+				Msg("closing idle connection")
+
+			if err := c.Close(); err != nil {
+				log.Debug().Err(err).Msg("failed to close idle connection")
+			}
+			continue
+		}
+
+		warn := e.IdleWarningTimeout > 0 && idle >= e.IdleWarningTimeout
+		if warn && e.OnIdleWarning != nil && st.idleWarned.CompareAndSwap(false, true) {
+			e.OnIdleWarning(c)
+		}
+	}
+
+	return idleTickInterval, gnet.None
+}
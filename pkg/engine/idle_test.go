@@ -0,0 +1,86 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestOnTickClosesIdleConnections(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	e.Clock = clk
+	e.IdleTimeout = time.Minute
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	clk.Advance(30 * time.Second)
+	enginetest.Traffic(e, c, frame(1, "keepalive"))
+
+	clk.Advance(45 * time.Second)
+	e.OnTick()
+	if c.Closed() {
+		t.Fatal("connection closed 45s after traffic, want it kept until 1m")
+	}
+
+	clk.Advance(15 * time.Second)
+	e.OnTick()
+	if !c.Closed() {
+		t.Fatal("connection still open after IdleTimeout")
+	}
+}
+
+func TestOnIdleWarningFiresOncePerIdlePeriod(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	e.Clock = clk
+	e.IdleTimeout = time.Minute
+	e.IdleWarningTimeout = 40 * time.Second
+
+	warnings := 0
+	e.OnIdleWarning = func(c gnet.Conn) {
+		warnings++
+		_ = c.AsyncWrite([]byte("ping?"), nil)
+	}
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	clk.Advance(30 * time.Second)
+	e.OnTick()
+	if warnings != 0 {
+		t.Fatalf("OnIdleWarning fired after 30s idle, want it at 40s")
+	}
+
+	clk.Advance(10 * time.Second)
+	e.OnTick()
+	clk.Advance(5 * time.Second)
+	e.OnTick()
+	if warnings != 1 || c.Closed() {
+		t.Fatalf("after 45s idle: %d warnings, closed %v, want 1 warning and the connection open", warnings, c.Closed())
+	}
+	if got := string(c.Written()); got != "ping?" {
+		t.Fatalf("Written = %q, want the warning's keepalive prompt", got)
+	}
+
+	// Traffic starts a new idle period, which warns again.
+	enginetest.Traffic(e, c, frame(1, ""))
+	clk.Advance(40 * time.Second)
+	e.OnTick()
+	if warnings != 2 {
+		t.Fatalf("%d warnings after a second idle period, want 2", warnings)
+	}
+
+	clk.Advance(20 * time.Second)
+	e.OnTick()
+	if !c.Closed() {
+		t.Fatal("connection still open after IdleTimeout")
+	}
+}
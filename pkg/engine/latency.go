@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// latencyWindow is the number of most recent samples kept per route or
+// middleware for percentile estimation.
+const latencyWindow = 1024
+
+// LatencyStats summarises the execution time of a single route or
+// middleware. Percentiles are computed over the most recent samples only.
+type LatencyStats struct {
+	Count uint64        // Total handled messages since boot
+	P50   time.Duration // Median over the recent window
+	P99   time.Duration // 99th percentile over the recent window
+}
+
+// latencySamples is the recent window of one route or middleware, with its
+// own lock so that routes never contend with each other.
+type latencySamples struct {
+	mu      sync.Mutex
+	count   uint64
+	samples []time.Duration
+	next    int
+}
+
+func (s *latencySamples) record(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count++
+	if len(s.samples) < latencyWindow {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % latencyWindow
+}
+
+func (s *latencySamples) stats() LatencyStats {
+	s.mu.Lock()
+	sorted := slices.Clone(s.samples)
+	count := s.count
+	s.mu.Unlock()
+
+	slices.Sort(sorted)
+	return LatencyStats{
+		Count: count,
+		P50:   percentile(sorted, 50),
+		P99:   percentile(sorted, 99),
+	}
+}
+
+// latencyRecorder holds the samples of every route key and middleware
+// name seen so far. Keys come from the route table rather than from peers,
+// so the number of windows is bounded by the routes ever registered.
+type latencyRecorder struct {
+	byRoute      sync.Map // route key -> *latencySamples
+	byMiddleware sync.Map // middleware name -> *latencySamples
+}
+
+func samplesFor(m *sync.Map, key any) *latencySamples {
+	if s, ok := m.Load(key); ok {
+		return s.(*latencySamples)
+	}
+	s, _ := m.LoadOrStore(key, &latencySamples{})
+	return s.(*latencySamples)
+}
+
+func snapshot[K comparable](m *sync.Map) map[K]LatencyStats {
+	out := make(map[K]LatencyStats)
+	m.Range(func(key, s any) bool {
+		out[key.(K)] = s.(*latencySamples).stats()
+		return true
+	})
+	return out
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// EnableLatencyStats starts recording handler and middleware execution
+// time, measured on the engine's Clock. It must be called before the
+// engine starts serving traffic and before TimedMiddleware is used.
+func (e *EngineWrapper[T]) EnableLatencyStats() {
+	e.latency = &latencyRecorder{}
+}
+
+// LatencyStats returns a snapshot of the recorded handler latencies, or nil
+// if latency recording is disabled. It is keyed by message ID, except that
+// every ID of a route spanning several IDs is reported under one key: the
+// RouteTable.Keys entry for ranges, Matcher.ID for matchers.
+func (e *EngineWrapper[T]) LatencyStats() map[int]LatencyStats {
+	if e.latency == nil {
+		return nil
+	}
+	return snapshot[int](&e.latency.byRoute)
+}
+
+// MiddlewareLatencyStats returns a snapshot of the latencies recorded by
+// TimedMiddleware, keyed by middleware name, or nil if latency recording is
+// disabled.
+func (e *EngineWrapper[T]) MiddlewareLatencyStats() map[string]LatencyStats {
+	if e.latency == nil {
+		return nil
+	}
+	return snapshot[string](&e.latency.byMiddleware)
+}
+
+// TimedMiddleware returns h, a handler wrapped by the middleware called
+// name, instrumented to record its execution time. The time includes
+// everything the middleware wraps, down to the route's handler. Without
+// EnableLatencyStats h is returned unchanged.
+func (e *EngineWrapper[T]) TimedMiddleware(name string, h handler.HandlerFunc) handler.HandlerFunc {
+	if e.latency == nil {
+		return h
+	}
+
+	s := samplesFor(&e.latency.byMiddleware, name)
+	return func(c gnet.Conn, body []byte) gnet.Action {
+		start := e.now()
+		action := h(c, body)
+		s.record(e.now().Sub(start))
+		return action
+	}
+}
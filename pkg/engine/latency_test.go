@@ -0,0 +1,67 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// sleeping returns a handler that takes d on clk.
+func sleeping(clk *clock.Fake, d time.Duration) handler.HandlerFunc {
+	return func(gnet.Conn, []byte) gnet.Action {
+		clk.Advance(d)
+		return gnet.None
+	}
+}
+
+func TestLatencyStatsRecordHandlerTime(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.Clock = clk
+	e.EnableLatencyStats()
+	e.SetRoutes(engine.RouteTable{
+		Handlers: map[int]handler.HandlerFunc{1: sleeping(clk, 20*time.Millisecond)},
+		Matchers: []engine.Matcher{{
+			ID:      0x80,
+			Match:   func(id int) bool { return id >= 0x80 },
+			Handler: sleeping(clk, 5*time.Millisecond),
+		}},
+	})
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+	for range 3 {
+		enginetest.Traffic(e, c, frame(1, ""))
+	}
+	for id := 0x80; id <= 0xFF; id++ {
+		enginetest.Traffic(e, c, frame(byte(id), ""))
+	}
+
+	stats := e.LatencyStats()
+	if len(stats) != 2 {
+		t.Fatalf("LatencyStats() = %v, want one entry per route", stats)
+	}
+	if got := stats[1]; got.Count != 3 || got.P50 != 20*time.Millisecond || got.P99 != 20*time.Millisecond {
+		t.Errorf("ID 1 stats = %+v, want 3 messages at 20ms", got)
+	}
+	if got := stats[0x80]; got.Count != 128 || got.P50 != 5*time.Millisecond {
+		t.Errorf("matcher stats = %+v, want 128 messages at 5ms", got)
+	}
+}
+
+func TestLatencyStatsDisabled(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+	enginetest.Traffic(e, c, frame(1, ""))
+
+	if e.LatencyStats() != nil || e.MiddlewareLatencyStats() != nil {
+		t.Fatal("latency stats reported without EnableLatencyStats")
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"bytes"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// verifyMagic checks that a connection opens with ProtocolMagic before any
+// frame is processed. The prefix is consumed once it matches.
+//
+// It returns false when traffic processing must stop, together with the
+// action to return: gnet.None while the prefix is still incomplete, or
+// gnet.Close if it does not match. UDP traffic has no connection to verify
+// and is never checked.
+func (e *EngineWrapper[T]) verifyMagic(c gnet.Conn) (bool, gnet.Action) {
+	if len(e.ProtocolMagic) == 0 {
+		return true, gnet.None
+	}
+
+	st := e.registry.state(c)
+	if st == nil || st.magicVerified {
+		return true, gnet.None
+	}
+
+	buf, err := c.Peek(len(e.ProtocolMagic))
+	if err != nil {
+		return false, gnet.None
+	}
+
+	if !bytes.Equal(buf, e.ProtocolMagic) {
+		log.Warn().
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Hex("expected", e.ProtocolMagic).
+			Hex("received", buf).
+			Msg("protocol magic mismatch, closing connection")
+
+		return false, gnet.Close
+	}
+
+	if _, err := c.Discard(len(e.ProtocolMagic)); err != nil {
+		return false, gnet.Close
+	}
+	st.magicVerified = true
+
+	return c.InboundBuffered() > 0, gnet.None
+}
@@ -0,0 +1,51 @@
+package engine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestProtocolMagicConsumedOnce(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: echo()})
+	e.ProtocolMagic = []byte("BMX\x01")
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	// The prefix may arrive in pieces, and share a read with the first frame.
+	if action := enginetest.Traffic(e, c, []byte("BM")); action != gnet.None {
+		t.Fatalf("OnTraffic on a partial magic = %v, want gnet.None", action)
+	}
+	enginetest.Traffic(e, c, append([]byte("X\x01"), frame(1, "one")...))
+	enginetest.Traffic(e, c, frame(1, "two"))
+
+	want := append(frame(0, "one"), frame(0, "two")...)
+	if got := c.Written(); !bytes.Equal(got, want) {
+		t.Fatalf("Written = %q, want %q", got, want)
+	}
+}
+
+func TestProtocolMagicMismatchCloses(t *testing.T) {
+	handled := false
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: func(gnet.Conn, []byte) gnet.Action {
+			handled = true
+			return gnet.None
+		}})
+	e.ProtocolMagic = []byte("BMX\x01")
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	if action := enginetest.Traffic(e, c, append([]byte("BMX\x02"), frame(1, "x")...)); action != gnet.Close {
+		t.Fatalf("OnTraffic with a wrong version byte = %v, want gnet.Close", action)
+	}
+	if handled {
+		t.Fatal("handler ran for a connection with the wrong magic")
+	}
+}
@@ -0,0 +1,12 @@
+package engine
+
+import "github.com/etwodev/bmux/pkg/handler"
+
+// Matcher handles every message ID accepted by Match. Matchers are only
+// consulted for IDs without a handler, in slice order.
+type Matcher struct {
+	ID      int // Reported in TopRoutes, e.g. the value of a mask route
+	Name    string
+	Match   func(msgID int) bool
+	Handler handler.HandlerFunc
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// frameRate estimates a connection's frames per second with a sliding
+// window: the previous one second bucket is weighted by how much of it
+// still overlaps the last second. It is only touched on the event loop.
+type frameRate struct {
+	start int64 // unix nanos at which the current bucket began
+	count int64
+	prev  int64
+}
+
+func (r *frameRate) allow(now time.Time, limit int) bool {
+	ns := now.UnixNano()
+	elapsed := ns - r.start
+
+	switch {
+	case elapsed >= 2*int64(time.Second):
+		r.start, r.prev, r.count = ns, 0, 0
+		elapsed = 0
+	case elapsed >= int64(time.Second):
+		r.start, r.prev, r.count = r.start+int64(time.Second), r.count, 0
+		elapsed -= int64(time.Second)
+	}
+
+	weight := float64(int64(time.Second)-elapsed) / float64(time.Second)
+	if float64(r.prev)*weight+float64(r.count) >= float64(limit) {
+		return false
+	}
+
+	r.count++
+	return true
+}
+
+// throttle enforces MaxFramesPerSecond on c once a frame has been read.
+// It returns false when the frame must not be dispatched, along with the
+// action to take: gnet.None to drop it, or gnet.Close if CloseOnFrameLimit.
+func (e *EngineWrapper[T]) throttle(c gnet.Conn) (bool, gnet.Action) {
+	if e.MaxFramesPerSecond <= 0 {
+		return true, gnet.None
+	}
+
+	st := e.registry.state(c)
+	if st == nil || st.rate.allow(e.now(), e.MaxFramesPerSecond) {
+		return true, gnet.None
+	}
+
+	if e.CloseOnFrameLimit {
+		e.frameWarn(c).
+			Str("remote", c.RemoteAddr().String()).
+			Str("conn", e.ConnID(c)).
+			Int("limit", e.MaxFramesPerSecond).
+			Msg("frame rate limit exceeded, closing connection")
+
+		return false, gnet.Close
+	}
+
+	e.frameWarn(c).
+		Str("remote", c.RemoteAddr().String()).
+		Str("conn", e.ConnID(c)).
+		Int("limit", e.MaxFramesPerSecond).
+		Msg("frame rate limit exceeded, dropping frame")
+
+	return false, gnet.None
+}
+
+// tokenBucket is a concurrency-safe token bucket refilling at rate tokens
+// per second up to burst. It starts full.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time, rate, burst int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*float64(rate))
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// admit enforces MaxAcceptsPerSecond across all event loops, allowing
+// bursts of up to AcceptBurst connections (MaxAcceptsPerSecond if unset).
+func (e *EngineWrapper[T]) admit(c gnet.Conn) bool {
+	if e.MaxAcceptsPerSecond <= 0 {
+		return true
+	}
+
+	burst := e.AcceptBurst
+	if burst <= 0 {
+		burst = e.MaxAcceptsPerSecond
+	}
+
+	if e.accepts.allow(e.now(), e.MaxAcceptsPerSecond, burst) {
+		return true
+	}
+
+	log.Warn().
+		Str("remote", c.RemoteAddr().String()).
+		Int("limit", e.MaxAcceptsPerSecond).
+		Msg("accept rate limit exceeded, refusing connection")
+
+	return false
+}
@@ -0,0 +1,51 @@
+package engine_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestAcceptRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		burst int
+		want  int // accepted in the first instant
+	}{
+		{"burst", 3, 3},
+		{"burst defaults to the rate", 0, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+			e.Clock = clk
+			e.MaxAcceptsPerSecond = 2
+			e.AcceptBurst = tc.burst
+
+			accept := func(n int) (accepted int) {
+				for range n {
+					c := enginetest.NewConn()
+					if _, action := e.OnOpen(c); action == gnet.None {
+						accepted++
+					} else {
+						e.OnClose(c, nil)
+					}
+				}
+				return accepted
+			}
+
+			if got := accept(5); got != tc.want {
+				t.Fatalf("accepted %d of 5 at once, want %d", got, tc.want)
+			}
+
+			// Tokens refill at the rate: one every 500ms.
+			clk.Advance(500 * time.Millisecond)
+			if got := accept(2); got != 1 {
+				t.Fatalf("accepted %d of 2 after 500ms, want 1", got)
+			}
+		})
+	}
+}
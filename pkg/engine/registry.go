@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// connState holds framework-owned per-connection data. It is stored in the
+// registry alongside the connection rather than in gnet's context slot, so
+// the user's *T context stays entirely under the application's control.
+type connState struct {
+	id            string              // assigned in OnOpen, immutable
+	tags          map[string]struct{} // guarded by registry.mu
+	writeFailures atomic.Int64        // consecutive failed async writes
+	magicVerified bool                // protocol magic consumed; event loop only
+	lastActive    atomic.Int64        // unix nanos of the last traffic
+	idleWarned    atomic.Bool         // idle warning fired for the current idle period
+	out           coalescer           // pending coalesced writes
+	rate          frameRate           // frames per second estimate; event loop only
+	replay        replayWindow        // sequence numbers seen; event loop only
+	warnings      warnBudget          // bad frame warnings, for frameWarn; event loop only
+}
+
+// registry tracks open connections and their connState, and indexes them
+// by tag so that callers can address groups of connections (rooms, topics, ...).
+type registry struct {
+	mu    sync.RWMutex
+	conns map[gnet.Conn]*connState
+	tags  map[string]map[gnet.Conn]struct{}
+}
+
+func (r *registry) add(c gnet.Conn, id string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns == nil {
+		r.conns = make(map[gnet.Conn]*connState)
+		r.tags = make(map[string]map[gnet.Conn]struct{})
+	}
+
+	st := &connState{id: id}
+	st.lastActive.Store(now.UnixNano())
+	r.conns[c] = st
+}
+
+// snapshot returns a copy of the registered connections and their state.
+func (r *registry) snapshot() map[gnet.Conn]*connState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make(map[gnet.Conn]*connState, len(r.conns))
+	for c, st := range r.conns {
+		conns[c] = st
+	}
+	return conns
+}
+
+// state returns the connState of c, or nil if c is not registered.
+func (r *registry) state(c gnet.Conn) *connState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.conns[c]
+}
+
+// remove unregisters c, reporting whether it was registered.
+func (r *registry) remove(c gnet.Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.conns[c]; !ok {
+		return false
+	}
+
+	r.untagLocked(c)
+	delete(r.conns, c)
+	return true
+}
+
+// setTags replaces the tags of c. Connections that are not registered
+// (already closed, or never opened) are ignored.
+func (r *registry) setTags(c gnet.Conn, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st, ok := r.conns[c]
+	if !ok {
+		return
+	}
+
+	r.untagLocked(c)
+
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		set[tag] = struct{}{}
+
+		members, ok := r.tags[tag]
+		if !ok {
+			members = make(map[gnet.Conn]struct{})
+			r.tags[tag] = members
+		}
+		members[c] = struct{}{}
+	}
+	st.tags = set
+}
+
+func (r *registry) untagLocked(c gnet.Conn) {
+	st, ok := r.conns[c]
+	if !ok {
+		return
+	}
+
+	for tag := range st.tags {
+		members := r.tags[tag]
+		delete(members, c)
+		if len(members) == 0 {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+// tagsOf returns the tags currently attached to c.
+func (r *registry) tagsOf(c gnet.Conn) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	st, ok := r.conns[c]
+	if !ok {
+		return nil
+	}
+
+	tags := make([]string, 0, len(st.tags))
+	for tag := range st.tags {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// tagged returns a snapshot of the connections carrying tag.
+func (r *registry) tagged(tag string) []gnet.Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conns := make([]gnet.Conn, 0, len(r.tags[tag]))
+	for c := range r.tags[tag] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// SetTags replaces the tags attached to c, e.g. "room:42". It is safe to
+// call from handlers and from other goroutines.
+func (e *EngineWrapper[T]) SetTags(c gnet.Conn, tags ...string) {
+	e.registry.setTags(c, tags...)
+}
+
+// Tags returns the tags currently attached to c.
+func (e *EngineWrapper[T]) Tags(c gnet.Conn) []string {
+	return e.registry.tagsOf(c)
+}
+
+// Tagged returns a snapshot of the open connections carrying tag.
+func (e *EngineWrapper[T]) Tagged(tag string) []gnet.Conn {
+	return e.registry.tagged(tag)
+}
@@ -0,0 +1,41 @@
+package engine_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+type session struct{ user string }
+
+func TestConnStateLeavesUserContextAlone(t *testing.T) {
+	e := enginetest.New(func() *session { return &session{} }, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: func(c gnet.Conn, body []byte) gnet.Action {
+			c.Context().(*session).user = string(body)
+			return gnet.None
+		}})
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+	e.SetTags(c, "lobby")
+
+	enginetest.Traffic(e, c, frame(1, "alice"))
+	if got := c.Context().(*session).user; got != "alice" {
+		t.Fatalf("context user = %q, want the value set by the handler", got)
+	}
+
+	// Framework data lives outside gnet's context slot, so replacing the
+	// context does not lose it.
+	c.SetContext("replaced by the application")
+	if id := e.ConnID(c); id == "" {
+		t.Error("ConnID lost after the context was replaced")
+	}
+	if got := e.Tags(c); !slices.Equal(got, []string{"lobby"}) {
+		t.Errorf("Tags = %v after the context was replaced, want [lobby]", got)
+	}
+	if got := c.Context(); got != "replaced by the application" {
+		t.Errorf("Context = %v, want the application's value untouched", got)
+	}
+}
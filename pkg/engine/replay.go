@@ -0,0 +1,79 @@
+package engine
+
+import "github.com/panjf2000/gnet/v2"
+
+// ExtractSequenceFunc returns the sequence number carried by a frame, and
+// false if the frame has none, in which case it is not replay checked.
+type ExtractSequenceFunc[T any] func(c gnet.Conn, head []byte, body []byte) (seq uint64, ok bool)
+
+// maxReplayWindow is the largest supported ReplayWindow, the width of the
+// per-connection bitmap of recently seen sequence numbers.
+const maxReplayWindow = 64
+
+// replayWindow is the anti-replay state of a connection, in the style of
+// IPsec: the highest sequence number seen, and a bitmap of which of the
+// numbers just below it have been seen. It is only touched on the event loop.
+type replayWindow struct {
+	started bool
+	high    uint64
+	seen    uint64 // bit i set: high-i has been seen
+}
+
+// accept records seq and reports whether it is new: above the highest seen,
+// or within window below it and not yet seen.
+func (w *replayWindow) accept(seq uint64, window int) bool {
+	if !w.started {
+		w.started, w.high, w.seen = true, seq, 1
+		return true
+	}
+
+	if seq > w.high {
+		shift := seq - w.high
+		if shift >= maxReplayWindow {
+			w.seen = 1
+		} else {
+			w.seen = w.seen<<shift | 1
+		}
+		w.high = seq
+		return true
+	}
+
+	age := w.high - seq
+	if age >= uint64(window) || w.seen&(1<<age) != 0 {
+		return false
+	}
+	w.seen |= 1 << age
+	return true
+}
+
+// checkReplay rejects frames whose sequence number was already seen on c
+// or is too old to tell, when ExtractSequence is set.
+func (e *EngineWrapper[T]) checkReplay(c gnet.Conn, head, body []byte) bool {
+	if e.ExtractSequence == nil {
+		return true
+	}
+
+	seq, ok := e.ExtractSequence(c, head, body)
+	if !ok {
+		return true
+	}
+
+	st := e.registry.state(c)
+	if st == nil {
+		return true
+	}
+
+	window := min(max(e.ReplayWindow, 1), maxReplayWindow)
+	if st.replay.accept(seq, window) {
+		return true
+	}
+
+	e.frameWarn(c).
+		Str("remote", c.RemoteAddr().String()).
+		Str("conn", e.ConnID(c)).
+		Uint64("seq", seq).
+		Uint64("highest", st.replay.high).
+		Msg("replayed or stale sequence number, dropping frame")
+
+	return false
+}
@@ -0,0 +1,50 @@
+package engine_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestReplayProtection(t *testing.T) {
+	var handled []byte
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3,
+		map[int]handler.HandlerFunc{1: func(_ gnet.Conn, body []byte) gnet.Action {
+			if len(body) > 0 {
+				handled = append(handled, body[0])
+			}
+			return gnet.None
+		}})
+	// The sequence number is the first body byte; empty bodies carry none.
+	e.ExtractSequence = func(_ gnet.Conn, _, body []byte) (uint64, bool) {
+		if len(body) == 0 {
+			return 0, false
+		}
+		return uint64(body[0]), true
+	}
+	e.ReplayWindow = 4
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+	for _, seq := range []byte{10, 10, 12, 11, 11, 8, 9, 100, 99, 50} {
+		enginetest.Traffic(e, c, frame(1, string([]byte{seq})))
+	}
+	enginetest.Traffic(e, c, frame(1, ""))
+
+	want := []byte{10, 12, 11, 9, 100, 99}
+	if !slices.Equal(handled, want) {
+		t.Fatalf("handled sequence numbers %v, want %v", handled, want)
+	}
+
+	// Each connection has its own window.
+	handled = nil
+	other := enginetest.NewConn()
+	e.OnOpen(other)
+	enginetest.Traffic(e, other, frame(1, string([]byte{10})))
+	if !slices.Equal(handled, []byte{10}) {
+		t.Fatalf("second connection handled %v, want [10]", handled)
+	}
+}
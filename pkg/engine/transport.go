@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Transport runs an event handler over a set of listen addresses. The
+// server depends on it rather than calling gnet directly, so tests and
+// alternative transports can drive the same EngineWrapper.
+type Transport interface {
+	// Run serves eventHandler on addrs and blocks until the transport stops.
+	Run(eventHandler gnet.EventHandler, addrs []string, opts ...gnet.Option) error
+
+	// Stop shuts the running transport down, closing all connections.
+	Stop(ctx context.Context) error
+}
+
+// NewGnetTransport returns the default Transport, backed by gnet.Rotate.
+func NewGnetTransport() Transport {
+	return &gnetTransport{}
+}
+
+type gnetTransport struct {
+	mu      sync.Mutex
+	eng     gnet.Engine
+	started bool
+}
+
+func (t *gnetTransport) Run(eventHandler gnet.EventHandler, addrs []string, opts ...gnet.Option) error {
+	return gnet.Rotate(&bootHook{EventHandler: eventHandler, t: t}, addrs, opts...)
+}
+
+func (t *gnetTransport) Stop(ctx context.Context) error {
+	t.mu.Lock()
+	eng, started := t.eng, t.started
+	t.mu.Unlock()
+
+	if !started {
+		return errors.New("Stop: transport is not running")
+	}
+	return eng.Stop(ctx)
+}
+
+// bootHook captures the gnet.Engine on boot so the transport can stop it.
+type bootHook struct {
+	gnet.EventHandler
+	t *gnetTransport
+}
+
+func (b *bootHook) OnBoot(eng gnet.Engine) gnet.Action {
+	b.t.mu.Lock()
+	b.t.eng, b.t.started = eng, true
+	b.t.mu.Unlock()
+
+	return b.EventHandler.OnBoot(eng)
+}
@@ -0,0 +1,24 @@
+package engine
+
+import "github.com/panjf2000/gnet/v2"
+
+// SetReady marks the engine ready (the default) or warming up. While it is
+// not ready OnOpen refuses every connection, writing WarmupReply first if set.
+// It is safe to call from any goroutine.
+func (e *EngineWrapper[T]) SetReady(ready bool) {
+	e.warming.Store(!ready)
+}
+
+// Ready reports whether the engine accepts connections once booted.
+func (e *EngineWrapper[T]) Ready() bool {
+	return !e.warming.Load()
+}
+
+// refuseWarming refuses c while the engine is warming up.
+func (e *EngineWrapper[T]) refuseWarming(c gnet.Conn) ([]byte, gnet.Action) {
+	log.Debug().
+		Str("remote", c.RemoteAddr().String()).
+		Msg("refusing connection while warming up")
+
+	return e.WarmupReply, gnet.Close
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/rs/zerolog"
+)
+
+// warnBudget counts a connection's bad frame warnings within the current
+// window. It is only touched on the event loop.
+type warnBudget struct {
+	start int64 // unix nanos at which the current window began
+	count int64
+}
+
+// next counts a warning at now and returns its position in the window,
+// starting a new window once window has elapsed since the last one began.
+func (b *warnBudget) next(now time.Time, window time.Duration) int64 {
+	ns := now.UnixNano()
+	if b.count == 0 || ns-b.start >= int64(window) {
+		b.start, b.count = ns, 0
+	}
+
+	b.count++
+	return b.count
+}
+
+// frameWarn starts a warning about a bad frame on c (malformed, oversized,
+// unknown or rejected), rate limited per connection so a flood of bad
+// frames cannot flood the logs: the first WarnLogBurst warnings of each
+// WarnLogWindow (one second if unset) are logged, then one in every
+// WarnLogSample, each carrying the running count for the window. The
+// window is measured on Clock, so a connection that misbehaves again later
+// is logged again rather than staying sampled for its whole lifetime.
+//
+// It returns nil when the warning is suppressed; zerolog treats a nil
+// event as disabled, so callers chain on it unconditionally.
+func (e *EngineWrapper[T]) frameWarn(c gnet.Conn) *zerolog.Event {
+	if e.WarnLogBurst <= 0 {
+		return log.Warn()
+	}
+
+	st := e.registry.state(c)
+	if st == nil {
+		return log.Warn()
+	}
+
+	window := e.WarnLogWindow
+	if window <= 0 {
+		window = time.Second
+	}
+
+	n := st.warnings.next(e.now(), window)
+	if n <= int64(e.WarnLogBurst) {
+		return log.Warn()
+	}
+
+	if e.WarnLogSample > 0 && (n-int64(e.WarnLogBurst))%int64(e.WarnLogSample) == 0 {
+		return log.Warn().Int64("occurrences", n)
+	}
+	return nil
+}
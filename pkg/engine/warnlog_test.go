@@ -0,0 +1,60 @@
+package engine_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+)
+
+func TestFrameWarningsBoundedPerWindow(t *testing.T) {
+	logs := captureLog(t)
+	clk := clock.NewFake(time.Unix(0, 0))
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.Clock = clk
+	e.WarnLogBurst = 3
+	e.WarnLogSample = 10
+	e.WarnLogWindow = time.Minute
+
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	flood := func() int {
+		logs.Reset()
+		for range 100 {
+			enginetest.Traffic(e, c, frame(9, "x"))
+		}
+		return bytes.Count(logs.Bytes(), []byte("no handler registered"))
+	}
+
+	// 3 burst warnings, then warnings 13, 23, ..., 93.
+	if n := flood(); n != 3+9 {
+		t.Fatalf("logged %d of 100 warnings, want 12", n)
+	}
+
+	clk.Advance(30 * time.Second)
+	if n := flood(); n != 10 {
+		t.Fatalf("logged %d of 100 warnings later in the window, want 10", n)
+	}
+
+	clk.Advance(30 * time.Second)
+	if n := flood(); n != 12 {
+		t.Fatalf("logged %d of 100 warnings in a new window, want the burst restored (12)", n)
+	}
+}
+
+func TestFrameWarningsUnlimitedWithoutBurst(t *testing.T) {
+	logs := captureLog(t)
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	c := enginetest.NewConn()
+	e.OnOpen(c)
+
+	for range 20 {
+		enginetest.Traffic(e, c, frame(9, "x"))
+	}
+	if n := bytes.Count(logs.Bytes(), []byte("no handler registered")); n != 20 {
+		t.Fatalf("logged %d of 20 warnings with WarnLogBurst unset, want 20", n)
+	}
+}
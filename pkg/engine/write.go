@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Write ordering
+//
+// Frames written to one connection never interleave, without any extra
+// locking in bmux: gnet owns each connection on a single event loop, and
+// AsyncWrite from other goroutines is queued onto that loop, where each
+// queued buffer is written in full before the next. Handlers run on the
+// loop itself, so their Write/Writev calls cannot interleave with it either.
+//
+// The rule for callers is therefore: hand gnet whole frames per call
+// (Write, Writev or AsyncWrite of a complete packet), and only use
+// AsyncWrite/AsyncWritev outside event loop callbacks.
+
+// AsyncWrite queues an already framed packet for c and tracks the outcome
+// in the connection state.
+//
+// Peers that vanish without a FIN keep accepting queued writes until the
+// kernel gives up, so consecutive failures are counted per connection; once
+// MaxWriteFailures is reached the connection is closed, which releases its
+// slot through OnClose. A successful write resets the count. A
+// MaxWriteFailures of 0 disables the reaper.
+//
+// When WriteCoalesceWindow is set, frames are buffered per connection and
+// flushed together; see coalesce. Only frames sent through AsyncWrite are
+// coalesced, so bytes written directly on the conn in the meantime may
+// overtake them.
+func (e *EngineWrapper[T]) AsyncWrite(c gnet.Conn, packet []byte) error {
+	e.capture(c, Outbound, -1, packet)
+
+	if e.WriteCoalesceWindow > 0 {
+		if st := e.registry.state(c); st != nil {
+			return e.coalesce(c, st, packet)
+		}
+	}
+	return e.asyncWrite(c, packet)
+}
+
+func (e *EngineWrapper[T]) asyncWrite(c gnet.Conn, packet []byte) error {
+	err := c.AsyncWrite(packet, e.trackWrite)
+	if err != nil {
+		e.writeFailed(c, err)
+	}
+	return err
+}
+
+func (e *EngineWrapper[T]) trackWrite(c gnet.Conn, err error) error {
+	if err != nil {
+		e.writeFailed(c, err)
+		return nil
+	}
+
+	if st := e.registry.state(c); st != nil {
+		st.writeFailures.Store(0)
+	}
+	return nil
+}
+
+func (e *EngineWrapper[T]) writeFailed(c gnet.Conn, err error) {
+	st := e.registry.state(c)
+	if st == nil || e.MaxWriteFailures <= 0 {
+		return
+	}
+
+	if st.writeFailures.Add(1) < e.MaxWriteFailures {
+		return
+	}
+
+	log.Warn().
+		Err(err).
+		Str("conn", st.id).
+		Int64("failures", st.writeFailures.Load()).
+		Msg("closing connection after repeated write failures")
+
+	if err := c.Close(); err != nil {
+		log.Debug().Err(err).Msg("failed to close connection")
+	}
+}
@@ -0,0 +1,65 @@
+package engine_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// flakyConn fails queued writes while failing is set, as a peer that
+// vanished without a FIN eventually does.
+type flakyConn struct {
+	*enginetest.Conn
+	failing bool
+}
+
+func (c *flakyConn) AsyncWrite(p []byte, callback gnet.AsyncCallback) error {
+	var err error
+	if c.failing {
+		err = errors.New("connection reset by peer")
+	} else {
+		_, err = c.Write(p)
+	}
+	return callback(c, err)
+}
+
+func TestRepeatedWriteFailuresCloseConn(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	e.MaxWriteFailures = 3
+	c := &flakyConn{Conn: enginetest.NewConn()}
+	e.OnOpen(c)
+
+	send := func(n int, failing bool) {
+		c.failing = failing
+		for range n {
+			_ = e.AsyncWrite(c, frame(0, "x"))
+		}
+	}
+
+	send(2, true)
+	send(1, false)
+	send(2, true)
+	if c.Closed() {
+		t.Fatal("connection closed after a success reset the failure count")
+	}
+
+	send(1, true)
+	if !c.Closed() {
+		t.Fatal("connection still open after MaxWriteFailures consecutive failures")
+	}
+}
+
+func TestWriteFailuresIgnoredWithoutLimit(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 3, nil)
+	c := &flakyConn{Conn: enginetest.NewConn(), failing: true}
+	e.OnOpen(c)
+
+	for range 100 {
+		_ = e.AsyncWrite(c, frame(0, "x"))
+	}
+	if c.Closed() {
+		t.Fatal("connection closed with MaxWriteFailures unset")
+	}
+}
@@ -0,0 +1,261 @@
+// Package enginetest provides helpers for exercising an engine.EngineWrapper
+// without a live gnet listener.
+//
+// Tests construct a wrapper with New, open an in-memory Conn, feed it crafted
+// frames and drive the event callbacks directly:
+//
+//	e := enginetest.New(ctxFactory, extractLen, extractID, 3, map[int]handler.HandlerFunc{
+//		0x01: HandlePing(),
+//	})
+//	c := enginetest.NewConn()
+//	e.OnOpen(c)
+//	action := enginetest.Traffic(e, c, frame)
+//	reply := c.Written()
+package enginetest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// New constructs an EngineWrapper with the given extractors and handlers,
+// no connection limit and the default SO_LINGER.
+func New[T any](
+	contextFactory func() *T,
+	extractLength engine.ExtractLengthFunc[T],
+	extractMsgID engine.ExtractMsgIDFunc[T],
+	headSize int,
+	handlers map[int]handler.HandlerFunc,
+) *engine.EngineWrapper[T] {
+	if handlers == nil {
+		handlers = make(map[int]handler.HandlerFunc)
+	}
+
+	e := &engine.EngineWrapper[T]{
+		ContextFactory: contextFactory,
+		ExtractLength:  extractLength,
+		ExtractMsgID:   extractMsgID,
+		HeadSize:       headSize,
+		MaxConnections: 1<<63 - 1,
+	}
+	e.SetHandlers(handlers)
+	return e
+}
+
+// Traffic appends frame to the inbound buffer of c and fires OnTraffic.
+func Traffic[T any](e *engine.EngineWrapper[T], c *Conn, frame []byte) gnet.Action {
+	c.Feed(frame)
+	return e.OnTraffic(c)
+}
+
+// Conn is an in-memory gnet.Conn backed by byte buffers.
+//
+// Only the methods used by the engine and common handlers are implemented;
+// calling any other gnet.Conn method panics.
+type Conn struct {
+	gnet.Conn
+
+	mu     sync.Mutex
+	in     []byte
+	out    bytes.Buffer
+	ctx    any
+	local  net.Addr
+	remote net.Addr
+	closed bool
+	linger *int
+}
+
+// NewConn returns an open Conn with loopback addresses.
+func NewConn() *Conn {
+	return NewConnFrom(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000})
+}
+
+// NewConnFrom returns an open Conn whose RemoteAddr is remote.
+func NewConnFrom(remote net.Addr) *Conn {
+	return &Conn{
+		local:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 30000},
+		remote: remote,
+	}
+}
+
+// Linger returns the value of the last SetLinger call, and false if
+// SetLinger was never called.
+func (c *Conn) Linger() (secs int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.linger == nil {
+		return 0, false
+	}
+	return *c.linger, true
+}
+
+// Feed appends data to the inbound buffer, as if the peer had sent it.
+func (c *Conn) Feed(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.in = append(c.in, data...)
+}
+
+// Written returns a copy of every byte written to the connection so far.
+func (c *Conn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.out.Bytes())
+}
+
+// Reset discards the bytes written so far.
+func (c *Conn) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Reset()
+}
+
+// Closed reports whether Close has been called.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// --- gnet.Reader ---
+
+// Next mirrors gnet: n <= 0 returns everything buffered and a short buffer
+// returns io.ErrShortBuffer without consuming anything.
+func (c *Conn) Next(n int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buf, err := c.peekLocked(n)
+	if err != nil {
+		return nil, err
+	}
+	c.in = c.in[len(buf):]
+	return buf, nil
+}
+
+func (c *Conn) Peek(n int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peekLocked(n)
+}
+
+func (c *Conn) peekLocked(n int) ([]byte, error) {
+	if n > len(c.in) {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = len(c.in)
+	}
+	return bytes.Clone(c.in[:n]), nil
+}
+
+func (c *Conn) Discard(n int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n <= 0 || n > len(c.in) {
+		n = len(c.in)
+	}
+	c.in = c.in[n:]
+	return n, nil
+}
+
+func (c *Conn) InboundBuffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.in)
+}
+
+// --- gnet.Writer ---
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.out.Write(p)
+}
+
+// Writev writes bs as one unit, as gnet does, so concurrent writers never
+// land between its buffers.
+func (c *Conn) Writev(bs [][]byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	var n int
+	for _, b := range bs {
+		w, _ := c.out.Write(b)
+		n += w
+	}
+	return n, nil
+}
+
+// AsyncWrite writes synchronously and then invokes callback.
+func (c *Conn) AsyncWrite(p []byte, callback gnet.AsyncCallback) error {
+	_, err := c.Write(p)
+	if callback != nil {
+		return callback(c, err)
+	}
+	return err
+}
+
+// AsyncWritev writes synchronously and then invokes callback.
+func (c *Conn) AsyncWritev(bs [][]byte, callback gnet.AsyncCallback) error {
+	_, err := c.Writev(bs)
+	if callback != nil {
+		return callback(c, err)
+	}
+	return err
+}
+
+func (c *Conn) Flush() error { return nil }
+
+func (c *Conn) OutboundBuffered() int { return 0 }
+
+// --- gnet.Conn ---
+
+func (c *Conn) Context() any { return c.ctx }
+
+func (c *Conn) SetContext(ctx any) { c.ctx = ctx }
+
+func (c *Conn) LocalAddr() net.Addr { return c.local }
+
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// SetLinger records secs for Linger; there is no socket to apply it to.
+func (c *Conn) SetLinger(secs int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.linger = &secs
+	return nil
+}
+
+// Close marks the connection closed. Unlike gnet it does not fire OnClose;
+// tests call it themselves when they need the close lifecycle.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// CloseWithCallback closes the connection and then invokes callback.
+func (c *Conn) CloseWithCallback(callback gnet.AsyncCallback) error {
+	err := c.Close()
+	if callback != nil {
+		return callback(c, err)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package enginetest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// upper replies with the body in upper case, framed like the request.
+func upper() handler.HandlerFunc {
+	return func(c gnet.Conn, body []byte) gnet.Action {
+		_, _ = c.Write(append([]byte{byte(len(body))}, bytes.ToUpper(body)...))
+		return gnet.None
+	}
+}
+
+func TestTrafficDrivesHandlers(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 1,
+		map[int]handler.HandlerFunc{0: upper()})
+	c := enginetest.NewConn()
+	if _, action := e.OnOpen(c); action != gnet.None {
+		t.Fatalf("OnOpen = %v, want gnet.None", action)
+	}
+
+	// Two frames in one read are both dispatched.
+	if action := enginetest.Traffic(e, c, []byte("\x02ab\x01c")); action != gnet.None {
+		t.Fatalf("OnTraffic = %v, want gnet.None", action)
+	}
+	if got, want := c.Written(), []byte("\x02AB\x01C"); !bytes.Equal(got, want) {
+		t.Fatalf("Written = %q, want %q", got, want)
+	}
+
+	// A frame split across reads is dispatched once it is complete.
+	c.Reset()
+	enginetest.Traffic(e, c, []byte("\x03d"))
+	if got := c.Written(); len(got) != 0 {
+		t.Fatalf("Written = %q for a partial frame, want nothing", got)
+	}
+	enginetest.Traffic(e, c, []byte("ef"))
+	if got, want := c.Written(), []byte("\x03DEF"); !bytes.Equal(got, want) {
+		t.Fatalf("Written = %q once the frame completed, want %q", got, want)
+	}
+
+	if action := e.OnClose(c, nil); action != gnet.None {
+		t.Fatalf("OnClose = %v, want gnet.None", action)
+	}
+}
+
+func TestClosedConnRefusesWrites(t *testing.T) {
+	c := enginetest.NewConn()
+	_ = c.Close()
+
+	if !c.Closed() {
+		t.Fatal("Closed = false after Close")
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("Write on a closed Conn succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+package enginetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Transport is an in-memory engine.Transport for deterministic tests.
+//
+// Run fires OnBoot and blocks until Stop. Connections are opened with Dial
+// and fed with Send; every event callback is serialized, as it would be on
+// a single gnet event loop.
+//
+//	t := enginetest.NewTransport()
+//	server := bmux.New(ctxFactory, extractLen, extractID, nil, bmux.WithTransport[MyContext](t))
+//	go server.Start()
+//	<-t.Ready()
+//	c := t.Dial()
+//	t.Send(c, frame)
+type Transport struct {
+	mu      sync.Mutex
+	handler gnet.EventHandler
+	conns   map[*Conn]struct{}
+	ready   chan struct{}
+	stopped chan struct{}
+	stop    sync.Once
+}
+
+// NewTransport returns an in-memory Transport that is not yet running.
+func NewTransport() *Transport {
+	return &Transport{
+		conns:   make(map[*Conn]struct{}),
+		ready:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Ready is closed once Run has fired OnBoot.
+func (t *Transport) Ready() <-chan struct{} {
+	return t.ready
+}
+
+func (t *Transport) Run(eventHandler gnet.EventHandler, _ []string, _ ...gnet.Option) error {
+	t.mu.Lock()
+	t.handler = eventHandler
+	eventHandler.OnBoot(gnet.Engine{})
+	t.mu.Unlock()
+
+	close(t.ready)
+	<-t.stopped
+	return nil
+}
+
+// Stop closes every open connection, fires OnShutdown and unblocks Run.
+func (t *Transport) Stop(_ context.Context) error {
+	t.stop.Do(func() {
+		t.mu.Lock()
+		for c := range t.conns {
+			t.closeLocked(c)
+		}
+		if t.handler != nil {
+			t.handler.OnShutdown(gnet.Engine{})
+		}
+		t.mu.Unlock()
+
+		close(t.stopped)
+	})
+	return nil
+}
+
+// Dial opens a new connection and fires OnOpen. Bytes returned by OnOpen
+// are written to the connection; if OnOpen refuses it, OnClose is fired and
+// the returned Conn is already closed.
+func (t *Transport) Dial() *Conn {
+	c := NewConn()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out, action := t.handler.OnOpen(c)
+	if len(out) > 0 {
+		_, _ = c.Write(out)
+	}
+	if action != gnet.None {
+		// gnet fires OnClose for refused connections too, and the engine
+		// relies on it to undo what OnOpen had already counted.
+		_ = c.Close()
+		t.handler.OnClose(c, nil)
+		return c
+	}
+
+	t.conns[c] = struct{}{}
+	return c
+}
+
+// Send feeds frame to c and fires OnTraffic. If the handler asks to close
+// the connection, OnClose is fired as well.
+func (t *Transport) Send(c *Conn, frame []byte) gnet.Action {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.Feed(frame)
+	action := t.handler.OnTraffic(c)
+	if action == gnet.Close {
+		t.closeLocked(c)
+	}
+	return action
+}
+
+// Hangup closes c from the peer side and fires OnClose.
+func (t *Transport) Hangup(c *Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeLocked(c)
+}
+
+func (t *Transport) closeLocked(c *Conn) {
+	if _, ok := t.conns[c]; !ok {
+		return
+	}
+
+	delete(t.conns, c)
+	_ = c.Close()
+	t.handler.OnClose(c, nil)
+}
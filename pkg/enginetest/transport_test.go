@@ -0,0 +1,37 @@
+package enginetest_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/panjf2000/gnet/v2"
+)
+
+type testContext struct{}
+
+func newContext() *testContext { return &testContext{} }
+
+func extractLength(_ gnet.Conn, buf []byte) (int, int) { return 0, int(buf[0]) }
+
+func extractMsgID(gnet.Conn, []byte, []byte) int { return 0 }
+
+func TestDialRefusedFiresOnClose(t *testing.T) {
+	e := enginetest.New(newContext, extractLength, extractMsgID, 1, nil)
+	e.OnConnect = append(e.OnConnect, func(gnet.Conn) error { return errors.New("no") })
+
+	tr := enginetest.NewTransport()
+	go func() { _ = tr.Run(e, nil) }()
+	<-tr.Ready()
+	defer func() { _ = tr.Stop(context.Background()) }()
+
+	c := tr.Dial()
+	if !c.Closed() {
+		t.Fatal("refused connection is still open")
+	}
+	if n := atomic.LoadInt64(&e.ActiveConnections); n != 0 {
+		t.Fatalf("ActiveConnections = %d after a refused Dial, want 0", n)
+	}
+}
@@ -4,5 +4,72 @@ import (
 	"github.com/panjf2000/gnet/v2"
 )
 
-// HandlerFunc processes a message, returns zero or more packets to write and an action
+// HandlerFunc processes a message body and returns the action to apply to the connection
 type HandlerFunc func(conn gnet.Conn, body []byte) gnet.Action
+
+// PacketsHandlerFunc processes a message, returns zero or more already framed packets to write and an action
+type PacketsHandlerFunc func(conn gnet.Conn, body []byte) (packets [][]byte, action gnet.Action)
+
+// Packets adapts a PacketsHandlerFunc into a HandlerFunc.
+//
+// The returned packets are written in order with a single Writev before the
+// action is applied. If the write fails the connection is closed.
+//
+// Example:
+//
+//	router.NewRoute("Sync", 0x02, true, false, handler.Packets(HandleSync()), nil)
+func Packets(fn PacketsHandlerFunc) HandlerFunc {
+	return func(conn gnet.Conn, body []byte) gnet.Action {
+		packets, action := fn(conn, body)
+		if len(packets) == 0 {
+			return action
+		}
+
+		if _, err := conn.Writev(packets); err != nil {
+			return gnet.Close
+		}
+		return action
+	}
+}
+
+// CloseWithReply queues an already framed reply and closes the connection
+// once the write has completed, so the peer receives the reply before the
+// close. Handlers return its result directly.
+//
+// Example:
+//
+//	if !valid {
+//		return handler.CloseWithReply(conn, violationPacket)
+//	}
+func CloseWithReply(conn gnet.Conn, packet []byte) gnet.Action {
+	err := conn.AsyncWrite(packet, func(c gnet.Conn, _ error) error {
+		return c.Close()
+	})
+	if err != nil {
+		return gnet.Close
+	}
+	return gnet.None
+}
+
+// ErrorHandlerFunc is a HandlerFunc that also reports failures. The error
+// is informational: the returned action applies whether or not it is nil.
+type ErrorHandlerFunc func(conn gnet.Conn, body []byte) (action gnet.Action, err error)
+
+// Errors adapts an ErrorHandlerFunc into a HandlerFunc, passing every
+// non-nil error to onError before the action is applied. router.NewErrorRoute
+// builds on it to log and count errors per route.
+//
+// Example:
+//
+//	h := handler.Errors(HandleLogin(), func(conn gnet.Conn, body []byte, err error) {
+//		metrics.LoginFailures.Inc()
+//	})
+func Errors(fn ErrorHandlerFunc, onError func(conn gnet.Conn, body []byte, err error)) HandlerFunc {
+	return func(conn gnet.Conn, body []byte) gnet.Action {
+		action, err := fn(conn, body)
+		if err != nil && onError != nil {
+			onError(conn, body, err)
+		}
+		return action
+	}
+}
@@ -0,0 +1,62 @@
+package handler_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestPacketsWritesInOrder(t *testing.T) {
+	h := handler.Packets(func(gnet.Conn, []byte) ([][]byte, gnet.Action) {
+		return [][]byte{[]byte("one,"), []byte("two")}, gnet.Close
+	})
+	c := enginetest.NewConn()
+
+	if action := h(c, nil); action != gnet.Close {
+		t.Fatalf("action = %v, want the handler's gnet.Close", action)
+	}
+	if got := string(c.Written()); got != "one,two" {
+		t.Fatalf("written %q, want %q", got, "one,two")
+	}
+}
+
+func TestCloseWithReplyClosesAfterWrite(t *testing.T) {
+	c := enginetest.NewConn()
+
+	if action := handler.CloseWithReply(c, []byte("bye")); action != gnet.None {
+		t.Fatalf("action = %v, want gnet.None", action)
+	}
+	if got := string(c.Written()); got != "bye" {
+		t.Fatalf("written %q, want %q", got, "bye")
+	}
+	if !c.Closed() {
+		t.Fatal("connection still open after the reply was written")
+	}
+}
+
+func TestErrorsReportsFailures(t *testing.T) {
+	failure := errors.New("bad login")
+	var reported []error
+	h := handler.Errors(func(_ gnet.Conn, body []byte) (gnet.Action, error) {
+		if string(body) == "bad" {
+			return gnet.Close, failure
+		}
+		return gnet.None, nil
+	}, func(_ gnet.Conn, _ []byte, err error) {
+		reported = append(reported, err)
+	})
+	c := enginetest.NewConn()
+
+	if action := h(c, []byte("good")); action != gnet.None {
+		t.Fatalf("action = %v, want gnet.None", action)
+	}
+	if action := h(c, []byte("bad")); action != gnet.Close {
+		t.Fatalf("action = %v, want gnet.Close", action)
+	}
+	if len(reported) != 1 || reported[0] != failure {
+		t.Fatalf("onError got %v, want [%v]", reported, failure)
+	}
+}
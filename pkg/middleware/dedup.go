@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// dedupEntry records when a key was first seen.
+type dedupEntry struct {
+	key string
+	at  time.Time
+}
+
+// dedupSet holds the recently seen keys, oldest first. All keys share one
+// ttl, so insertion order is also expiry order.
+type dedupSet struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []dedupEntry
+}
+
+// seenRecently records key and reports whether it was already recorded
+// within ttl. A new key evicts the oldest ones once maxKeys are remembered.
+func (d *dedupSet) seenRecently(key string, now time.Time, ttl time.Duration, maxKeys int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.order) > 0 && now.Sub(d.order[0].at) >= ttl {
+		d.evictOldest()
+	}
+
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+
+	for len(d.order) >= maxKeys {
+		d.evictOldest()
+	}
+	d.seen[key] = now
+	d.order = append(d.order, dedupEntry{key, now})
+	return false
+}
+
+func (d *dedupSet) evictOldest() {
+	oldest := d.order[0]
+	d.order = d.order[1:]
+	delete(d.seen, oldest.key)
+}
+
+// NewDedupMiddleware returns a middleware named "dedup" that skips the
+// handler for messages whose idempotency key was already seen within ttl,
+// for clients that retransmit. keyFn extracts the key from a message; an
+// empty key is never deduplicated.
+//
+// At most maxKeys keys are remembered, across all connections; beyond that
+// the oldest are forgotten early. A duplicate runs onDuplicate instead of
+// the handler if it is non-nil, e.g. to acknowledge it again, and is
+// otherwise dropped.
+//
+// ttl is measured on clk, e.g. a clock.Fake in tests; nil means clock.Real.
+//
+// Example:
+//
+//	dedup := middleware.NewDedupMiddleware(func(conn gnet.Conn, body []byte) string {
+//		return requestID(body)
+//	}, time.Minute, 100_000, nil, nil)
+//	server.LoadMiddleware([]middleware.Middleware{dedup})
+func NewDedupMiddleware(
+	keyFn func(conn gnet.Conn, body []byte) string,
+	ttl time.Duration,
+	maxKeys int,
+	onDuplicate handler.HandlerFunc,
+	clk clock.Clock,
+	opts ...MiddlewareWrapper,
+) Middleware {
+	if clk == nil {
+		clk = clock.Real
+	}
+
+	set := &dedupSet{seen: make(map[string]time.Time)}
+
+	method := func(next handler.HandlerFunc) handler.HandlerFunc {
+		return func(conn gnet.Conn, body []byte) gnet.Action {
+			key := keyFn(conn, body)
+			if key == "" || !set.seenRecently(key, clk.Now(), ttl, max(maxKeys, 1)) {
+				return next(conn, body)
+			}
+
+			if onDuplicate != nil {
+				return onDuplicate(conn, body)
+			}
+			return gnet.None
+		}
+	}
+
+	return NewMiddleware(method, "dedup", true, false, opts...)
+}
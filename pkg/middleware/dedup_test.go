@@ -0,0 +1,72 @@
+package middleware_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/middleware"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestDedupForgetsKeysAfterTTL(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	dedup := middleware.NewDedupMiddleware(func(_ gnet.Conn, body []byte) string {
+		return string(body)
+	}, time.Minute, 10, nil, clk)
+
+	calls := 0
+	h := dedup.Method()(func(gnet.Conn, []byte) gnet.Action {
+		calls++
+		return gnet.None
+	})
+	c := enginetest.NewConn()
+
+	h(c, []byte("req-1"))
+	clk.Advance(59 * time.Second)
+	h(c, []byte("req-1"))
+	if calls != 1 {
+		t.Fatalf("handler ran %d times for a retransmit within ttl, want 1", calls)
+	}
+
+	clk.Advance(time.Second)
+	h(c, []byte("req-1"))
+	if calls != 2 {
+		t.Fatalf("handler ran %d times after ttl expired, want 2", calls)
+	}
+}
+
+func TestDedupCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		maxKeys int
+		keys    []string
+		want    int
+	}{
+		{"repeats within one slot", 1, []string{"A", "A", "A"}, 1},
+		{"duplicate with room", 2, []string{"A", "B", "A"}, 2},
+		{"duplicates keep the set", 2, []string{"A", "B", "A", "A", "B"}, 2},
+		{"new key evicts oldest", 2, []string{"A", "B", "C", "A"}, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dedup := middleware.NewDedupMiddleware(func(_ gnet.Conn, body []byte) string {
+				return string(body)
+			}, time.Minute, tc.maxKeys, nil, clock.NewFake(time.Unix(0, 0)))
+
+			calls := 0
+			h := dedup.Method()(func(gnet.Conn, []byte) gnet.Action {
+				calls++
+				return gnet.None
+			})
+			c := enginetest.NewConn()
+			for _, key := range tc.keys {
+				h(c, []byte(key))
+			}
+
+			if calls != tc.want {
+				t.Fatalf("handler ran %d times for %v, want %d", calls, tc.keys, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package parsing
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// FixedCodec reads and writes a message ID stored as a raw unsigned
+// integer at a fixed offset in the header of a canonical frame, for simple
+// binary protocols with no header encoding of their own.
+//
+// For example, FixedCodec{MsgIDSize: 4} describes frames whose header is
+// just a 4 byte little endian message ID.
+type FixedCodec struct {
+	MsgIDOffset int              // Offset of the message ID within the header
+	MsgIDSize   int              // Size of the message ID in bytes: 1, 2, 4 or 8
+	ByteOrder   binary.ByteOrder // Defaults to binary.LittleEndian, as the length prefix
+}
+
+func (f FixedCodec) order() binary.ByteOrder {
+	if f.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return f.ByteOrder
+}
+
+// validate reports an unsupported MsgIDSize or a negative MsgIDOffset.
+func (f FixedCodec) validate() error {
+	switch f.MsgIDSize {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("unsupported message ID size %d", f.MsgIDSize)
+	}
+	if f.MsgIDOffset < 0 {
+		return fmt.Errorf("negative message ID offset %d", f.MsgIDOffset)
+	}
+	return nil
+}
+
+// MsgID returns the message ID in head, or -1 if head is too short, MsgIDSize
+// is unsupported or MsgIDOffset is negative.
+func (f FixedCodec) MsgID(head []byte) int {
+	if f.validate() != nil || len(head) < f.MsgIDOffset+f.MsgIDSize {
+		return -1
+	}
+
+	b := head[f.MsgIDOffset : f.MsgIDOffset+f.MsgIDSize]
+	switch f.MsgIDSize {
+	case 1:
+		return int(b[0])
+	case 2:
+		return int(f.order().Uint16(b))
+	case 4:
+		return int(f.order().Uint32(b))
+	case 8:
+		return int(f.order().Uint64(b))
+	}
+	return -1
+}
+
+// Frame builds a canonical packet whose header is MsgIDOffset zero bytes
+// followed by msgID.
+//
+// Returns an error if MsgIDSize is unsupported, MsgIDOffset is negative,
+// msgID does not fit in MsgIDSize, or the body is too large.
+func (f FixedCodec) Frame(msgID int, body []byte) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("Frame: %w", err)
+	}
+
+	if msgID < 0 || (f.MsgIDSize < 8 && uint64(msgID) >= 1<<(8*f.MsgIDSize)) {
+		return nil, fmt.Errorf("Frame: message ID %d does not fit in %d bytes", msgID, f.MsgIDSize)
+	}
+
+	head := make([]byte, f.MsgIDOffset+f.MsgIDSize)
+	b := head[f.MsgIDOffset:]
+	switch f.MsgIDSize {
+	case 1:
+		b[0] = byte(msgID)
+	case 2:
+		f.order().PutUint16(b, uint16(msgID))
+	case 4:
+		f.order().PutUint32(b, uint32(msgID))
+	case 8:
+		f.order().PutUint64(b, uint64(msgID))
+	}
+
+	return Frame(head, body)
+}
+
+// FixedExtractMsgID returns a message ID extractor for codec, to pass to
+// bmux.New alongside DefaultExtractLength.
+//
+// Example:
+//
+//	codec := parsing.FixedCodec{MsgIDSize: 4, ByteOrder: binary.BigEndian}
+//	server := bmux.New(ctxFactory, parsing.DefaultExtractLength[MyContext](), parsing.FixedExtractMsgID[MyContext](codec), nil)
+func FixedExtractMsgID[T any](codec FixedCodec) engine.ExtractMsgIDFunc[T] {
+	return func(c gnet.Conn, head []byte, body []byte) int {
+		return codec.MsgID(head)
+	}
+}
@@ -0,0 +1,41 @@
+package parsing_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/parsing"
+)
+
+func TestFixedCodecRoundTrip(t *testing.T) {
+	for _, size := range []int{1, 2, 4, 8} {
+		codec := parsing.FixedCodec{MsgIDOffset: 2, MsgIDSize: size, ByteOrder: binary.BigEndian}
+		packet, err := codec.Frame(0x7F, []byte("body"))
+		if err != nil {
+			t.Fatalf("size %d: Frame: %v", size, err)
+		}
+
+		head := packet[3 : 3+int(packet[0])]
+		if got := codec.MsgID(head); got != 0x7F {
+			t.Fatalf("size %d: MsgID = %#x, want 0x7f", size, got)
+		}
+	}
+}
+
+func TestFixedCodecRejectsInvalidLayout(t *testing.T) {
+	head := make([]byte, 16)
+	for _, codec := range []parsing.FixedCodec{
+		{MsgIDSize: 0},
+		{MsgIDSize: 3},
+		{MsgIDSize: -1},
+		{MsgIDSize: -8},
+		{MsgIDOffset: -1, MsgIDSize: 2},
+	} {
+		if got := codec.MsgID(head); got != -1 {
+			t.Errorf("%+v: MsgID = %d, want -1", codec, got)
+		}
+		if _, err := codec.Frame(1, nil); err == nil {
+			t.Errorf("%+v: Frame succeeded, want an error", codec)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package parsing
+
+import "bytes"
+
+// LineFramer is an engine.Framer for newline-delimited protocols. Each
+// frame ends with '\n'; the delimiter, and a preceding '\r' if present, is
+// stripped from the body passed to handlers. Frames have no header.
+type LineFramer struct {
+	// MaxLine is the longest accepted line in bytes, delimiter included.
+	// A longer unterminated line closes the connection. Zero means no
+	// limit, leaving MaxPendingInbound as the only bound.
+	MaxLine int
+}
+
+// NextFrame returns the length of the first line in buffered, delimiter
+// included.
+func (f LineFramer) NextFrame(buffered []byte) (int, bool) {
+	i := bytes.IndexByte(buffered, '\n')
+	if i < 0 {
+		if f.MaxLine > 0 && len(buffered) >= f.MaxLine {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if f.MaxLine > 0 && i+1 > f.MaxLine {
+		return 0, true
+	}
+	return i + 1, true
+}
+
+// Split returns the line without its delimiter as the body.
+func (f LineFramer) Split(frame []byte) (head, body []byte) {
+	body = bytes.TrimSuffix(frame, []byte("\n"))
+	return nil, bytes.TrimSuffix(body, []byte("\r"))
+}
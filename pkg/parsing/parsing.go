@@ -0,0 +1,108 @@
+// Package parsing provides the canonical bmux framing:
+//
+//	| 0       | 1 2                  | 3 ... 3+n-1      | 3+n ... 3+n+m-1 |
+//	|---------|----------------------|------------------|-----------------|
+//	| headLen | bodyLen (2 bytes LE) | header (n bytes) | body (m bytes)  |
+//
+// DefaultExtractLength can be passed straight to bmux.New, and Frame
+// produces packets that it parses, so the two never drift apart.
+//
+// Either length may be zero. The engine then hands ExtractMsgID a nil
+// header and handlers an empty (non-nil) body, so a frame of just the
+// three prefix bytes is valid.
+package parsing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	// HeadSize is the size of the length prefix, to be used as the headSize config value.
+	HeadSize = 3
+
+	// MaxHeadLen is the largest header a single length byte can describe.
+	MaxHeadLen = 1<<8 - 1
+
+	// MaxBodyLen is the largest body a two byte length can describe.
+	MaxBodyLen = 1<<16 - 1
+)
+
+// DefaultExtractLength returns an extractor for the canonical framing.
+//
+// Example:
+//
+//	server := bmux.New(ctxFactory, parsing.DefaultExtractLength[MyContext](), extractID, nil)
+func DefaultExtractLength[T any]() engine.ExtractLengthFunc[T] {
+	return func(c gnet.Conn, buf []byte) (headLen int, totalLen int) {
+		if len(buf) < HeadSize {
+			return 0, -1
+		}
+
+		headLen = int(buf[0])
+		totalLen = headLen + int(binary.LittleEndian.Uint16(buf[1:3]))
+		return headLen, totalLen
+	}
+}
+
+// Frame builds a canonical packet from head and body.
+//
+// Returns an error if either part is too large for its length field.
+func Frame(head, body []byte) ([]byte, error) {
+	if len(head) > MaxHeadLen {
+		return nil, fmt.Errorf("Frame: header is %d bytes, limit is %d", len(head), MaxHeadLen)
+	}
+
+	if len(body) > MaxBodyLen {
+		return nil, fmt.Errorf("Frame: body is %d bytes, limit is %d", len(body), MaxBodyLen)
+	}
+
+	packet := make([]byte, HeadSize+len(head)+len(body))
+	packet[0] = byte(len(head))
+	binary.LittleEndian.PutUint16(packet[1:3], uint16(len(body)))
+	copy(packet[HeadSize:], head)
+	copy(packet[HeadSize+len(head):], body)
+	return packet, nil
+}
+
+// ReplayCapture reads a capture written by bmux.WithCapture (or
+// engine.Capture) from r and calls fn with each record in order. It stops
+// at the first error returned by fn, and returns nil at a clean end of file.
+//
+// Example:
+//
+//	f, _ := os.Open("bmux.capture")
+//	err := parsing.ReplayCapture(f, func(rec engine.CaptureRecord) error {
+//		fmt.Printf("%s %s %#x %x\n", rec.Time, rec.ConnID, rec.MsgID, rec.Frame)
+//		return nil
+//	})
+func ReplayCapture(r io.Reader, fn func(rec engine.CaptureRecord) error) error {
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("ReplayCapture: failed reading record length: %w", err)
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("ReplayCapture: failed reading record: %w", err)
+		}
+
+		var rec engine.CaptureRecord
+		if err := rec.UnmarshalBinary(buf); err != nil {
+			return fmt.Errorf("ReplayCapture: %w", err)
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
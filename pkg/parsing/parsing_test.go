@@ -0,0 +1,102 @@
+package parsing_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/engine"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/parsing"
+)
+
+type testContext struct{}
+
+func TestDefaultExtractLength(t *testing.T) {
+	extract := parsing.DefaultExtractLength[testContext]()
+	c := enginetest.NewConn()
+
+	for _, tc := range []struct {
+		name             string
+		buf              []byte
+		wantHead, wantTL int
+	}{
+		{"empty buffer", nil, 0, -1},
+		{"one prefix byte", []byte{1}, 0, -1},
+		{"two prefix bytes", []byte{1, 0}, 0, -1},
+		{"empty frame", []byte{0, 0, 0}, 0, 0},
+		{"head only", []byte{4, 0, 0}, 4, 4},
+		{"body length is little endian", []byte{1, 0x02, 0x01}, 1, 1 + 0x0102},
+		{"largest lengths", []byte{0xFF, 0xFF, 0xFF}, parsing.MaxHeadLen, parsing.MaxHeadLen + parsing.MaxBodyLen},
+		{"prefix of a longer buffer", []byte{2, 3, 0, 'h', 'd', 'b', 'o', 'd'}, 2, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			head, total := extract(c, tc.buf)
+			if head != tc.wantHead || total != tc.wantTL {
+				t.Fatalf("extract(%v) = %d, %d, want %d, %d", tc.buf, head, total, tc.wantHead, tc.wantTL)
+			}
+		})
+	}
+}
+
+func TestFrame(t *testing.T) {
+	extract := parsing.DefaultExtractLength[testContext]()
+	c := enginetest.NewConn()
+
+	for _, tc := range []struct {
+		name       string
+		head, body []byte
+		wantErr    bool
+	}{
+		{"both empty", nil, nil, false},
+		{"empty head", nil, []byte("body"), false},
+		{"empty body", []byte{0x01}, nil, false},
+		{"largest head", bytes.Repeat([]byte{1}, parsing.MaxHeadLen), []byte("x"), false},
+		{"largest body", []byte{1}, bytes.Repeat([]byte{2}, parsing.MaxBodyLen), false},
+		{"head too large", bytes.Repeat([]byte{1}, parsing.MaxHeadLen+1), nil, true},
+		{"body too large", nil, bytes.Repeat([]byte{2}, parsing.MaxBodyLen+1), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			packet, err := parsing.Frame(tc.head, tc.body)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Frame succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Frame: %v", err)
+			}
+
+			// Round trip through the extractor, as the engine slices frames.
+			hd, total := extract(c, packet)
+			if len(packet) != parsing.HeadSize+total {
+				t.Fatalf("packet is %d bytes, extractor expects %d", len(packet), parsing.HeadSize+total)
+			}
+			frame := packet[parsing.HeadSize:]
+			if !bytes.Equal(frame[:hd], tc.head) || !bytes.Equal(frame[hd:], tc.body) {
+				t.Fatalf("round trip gave head %v, body %d bytes, want head %v, body %d bytes",
+					frame[:hd], len(frame[hd:]), tc.head, len(tc.body))
+			}
+		})
+	}
+}
+
+func TestReplayCaptureTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	capture := engine.NewCapture(&buf)
+	for i := range 2 {
+		if err := capture.Record(engine.CaptureRecord{ConnID: "1", MsgID: i, Frame: []byte("frame")}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	stream := buf.Bytes()
+	replayed := 0
+	err := parsing.ReplayCapture(bytes.NewReader(stream[:len(stream)-2]), func(engine.CaptureRecord) error {
+		replayed++
+		return nil
+	})
+	if err == nil || replayed != 1 {
+		t.Fatalf("ReplayCapture of a truncated stream: %d records, err %v, want 1 record and an error", replayed, err)
+	}
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// breaker tracks the panics of one route. It is shared by every event loop.
+type breaker struct {
+	mu       sync.Mutex
+	panics   int       // consecutive panics in the current window
+	since    time.Time // first panic of the current window
+	openedAt time.Time // zero while the circuit is closed
+	probing  bool      // a half-open trial invocation is running
+}
+
+// allow reports whether an invocation may run the handler.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if now.Sub(b.openedAt) < cooldown || b.probing {
+		return false
+	}
+
+	// Half-open: let one invocation through to test the handler.
+	b.probing = true
+	return true
+}
+
+func (b *breaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.panics, b.openedAt, b.probing = 0, time.Time{}, false
+}
+
+// panicked records a panic and reports whether it opened the circuit.
+func (b *breaker) panicked(now time.Time, threshold int, window time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.probing {
+		b.openedAt, b.probing = now, false
+		return true
+	}
+
+	if b.panics == 0 || now.Sub(b.since) > window {
+		b.panics, b.since = 0, now
+	}
+	b.panics++
+
+	if b.panics < threshold {
+		return false
+	}
+	b.panics, b.openedAt = 0, now
+	return true
+}
+
+// WithCircuitBreaker recovers panics in the route's handler, closing the
+// connection whose message caused one. After threshold consecutive panics
+// within window the circuit opens: for cooldown the handler is skipped and
+// onOpen, if non-nil, runs in its place, e.g. to reply with an error frame;
+// without onOpen messages are dropped. Once cooldown has passed a single
+// message is let through, closing the circuit if it succeeds and opening it
+// again if it panics.
+//
+// window and cooldown are measured on clk, e.g. a clock.Fake in tests; nil
+// means clock.Real.
+//
+// Example:
+//
+//	router.NewRoute("Render", 0x30, true, false, HandleRender(), nil,
+//		router.WithCircuitBreaker(5, time.Minute, 30*time.Second, ReplyUnavailable(), nil))
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration, onOpen handler.HandlerFunc, clk clock.Clock) RouteWrapper {
+	if clk == nil {
+		clk = clock.Real
+	}
+
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok || threshold <= 0 {
+			return r
+		}
+
+		b := &breaker{}
+		next := rt.handler
+		name, id := rt.name, rt.id
+
+		rt.handler = func(conn gnet.Conn, body []byte) (action gnet.Action) {
+			if !b.allow(clk.Now(), cooldown) {
+				if onOpen != nil {
+					return onOpen(conn, body)
+				}
+				return gnet.None
+			}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+
+				log.Error().
+					Str("Name", name).
+					Int("RouteID", id).
+					Interface("Panic", p).
+					Str("Stack", string(debug.Stack())).
+					Msg("route handler panicked, closing connection")
+
+				if b.panicked(clk.Now(), threshold, window) {
+					log.Warn().
+						Str("Name", name).
+						Int("RouteID", id).
+						Dur("Cooldown", cooldown).
+						Msg("route circuit opened after repeated panics")
+				}
+				action = gnet.Close
+			}()
+
+			action = next(conn, body)
+			b.succeeded()
+			return action
+		}
+		return rt
+	}
+}
@@ -0,0 +1,51 @@
+package router_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/clock"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	fail := true
+	rejected := 0
+
+	rt := router.NewRoute("Flaky", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		if fail {
+			panic("flaky")
+		}
+		return gnet.None
+	}, nil, router.WithCircuitBreaker(2, time.Minute, 30*time.Second,
+		func(gnet.Conn, []byte) gnet.Action {
+			rejected++
+			return gnet.None
+		}, clk))
+	h := rt.Handler()
+	c := enginetest.NewConn()
+
+	for range 2 {
+		if action := h(c, nil); action != gnet.Close {
+			t.Fatalf("panicking handler returned %v, want gnet.Close", action)
+		}
+	}
+
+	h(c, nil)
+	if rejected != 1 {
+		t.Fatalf("onOpen ran %d times while open, want 1", rejected)
+	}
+
+	clk.Advance(30 * time.Second)
+	fail = false
+	if action := h(c, nil); action != gnet.None || rejected != 1 {
+		t.Fatalf("probe after cooldown = %v with %d rejections, want gnet.None and 1", action, rejected)
+	}
+	h(c, nil)
+	if rejected != 1 {
+		t.Fatalf("onOpen ran after the circuit closed")
+	}
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"sync/atomic"
+
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// ErrorCounter is implemented by routes that count their handler's errors,
+// such as those built by NewErrorRoute.
+type ErrorCounter interface {
+	// Errors returns the number of errors returned by the handler so far.
+	Errors() uint64
+}
+
+type errorRoute struct {
+	route
+	errors *atomic.Uint64
+}
+
+func (r errorRoute) Errors() uint64 {
+	return r.errors.Load()
+}
+
+// NewErrorRoute creates a route from an error-returning handler. Every
+// non-nil error is logged with the route's name and message ID and counted
+// (see ErrorCounter and Server.RouteErrors); the returned action applies
+// either way. opts are applied as for NewRoute.
+//
+// Example:
+//
+//	router.NewErrorRoute("Login", 0x05, true, false, func(conn gnet.Conn, body []byte) (gnet.Action, error) {
+//		if err := login(conn, body); err != nil {
+//			return gnet.Close, fmt.Errorf("login: %w", err)
+//		}
+//		return gnet.None, nil
+//	}, nil)
+func NewErrorRoute(
+	name string,
+	id int,
+	status, experimental bool,
+	fn handler.ErrorHandlerFunc,
+	middleware []func(handler.HandlerFunc) handler.HandlerFunc,
+	opts ...RouteWrapper,
+) Route {
+	errors := &atomic.Uint64{}
+
+	h := handler.Errors(fn, func(conn gnet.Conn, _ []byte, err error) {
+		errors.Add(1)
+		log.Warn().
+			Err(err).
+			Str("Name", name).
+			Int("RouteID", id).
+			Str("Remote", conn.RemoteAddr().String()).
+			Msg("route handler returned an error")
+	})
+
+	return errorRoute{
+		route:  build(name, id, status, experimental, h, middleware, opts),
+		errors: errors,
+	}
+}
@@ -0,0 +1,39 @@
+package router
+
+import "github.com/etwodev/bmux/pkg/handler"
+
+// RouteGroup collects routes that share a status and a middleware stack,
+// and builds them into a single Router. It is sugar over NewRouter and
+// NewRoute.
+type RouteGroup struct {
+	status     bool
+	middleware []func(handler.HandlerFunc) handler.HandlerFunc
+	routes     []Route
+}
+
+// Group starts a route group. Every route added with Handle is enabled
+// while the group's status is, and runs middleware, in order, before its
+// own route-level middleware.
+//
+// Example:
+//
+//	admin := router.Group(true, RequireAdmin(), AuditLog())
+//	admin.Handle(0xF001, "Drain", HandleDrain())
+//	admin.Handle(0xF002, "Stats", HandleStats(), router.WithPriority(1))
+//	server.LoadRouter([]router.Router{admin.Router()})
+func Group(status bool, middleware ...func(handler.HandlerFunc) handler.HandlerFunc) *RouteGroup {
+	return &RouteGroup{status: status, middleware: middleware}
+}
+
+// Handle adds an enabled, non-experimental route to the group and returns
+// the group for chaining. opts are applied as for NewRoute.
+func (g *RouteGroup) Handle(id int, name string, fn handler.HandlerFunc, opts ...RouteWrapper) *RouteGroup {
+	g.routes = append(g.routes, NewRoute(name, id, true, false, fn, nil, opts...))
+	return g
+}
+
+// Router returns a Router serving the group's routes, with the group's
+// middleware as router-level middleware.
+func (g *RouteGroup) Router(opts ...RouterWrapper) Router {
+	return NewRouter(g.status, g.routes, g.middleware, opts...)
+}
@@ -11,6 +11,8 @@ type route struct {
 	experimental bool
 	handler      handler.HandlerFunc
 	middleware   []func(handler.HandlerFunc) handler.HandlerFunc
+	priority     int
+	predicate    func() bool
 }
 
 type router struct {
@@ -26,7 +28,7 @@ func (r route) ID() int {
 }
 
 func (r route) Status() bool {
-	return r.status
+	return r.status && (r.predicate == nil || r.predicate())
 }
 
 func (r route) Experimental() bool {
@@ -45,6 +47,10 @@ func (r route) Name() string {
 	return r.name
 }
 
+func (r route) Priority() int {
+	return r.priority
+}
+
 // --- Router implementation ---
 
 func (r router) Routes() []Route {
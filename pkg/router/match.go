@@ -0,0 +1,124 @@
+package router
+
+import "github.com/etwodev/bmux/pkg/handler"
+
+// Ranged is implemented by routes that handle every message ID in the
+// inclusive range [low, high]. The server registers such a route under each
+// ID of the range, exactly as if one route had been added per ID.
+//
+// A range spanning more than MaxExpandedRange IDs is registered as a
+// Matcher instead, to bound the memory used by the handler table: it then
+// only handles IDs that have no exact route.
+type Ranged interface {
+	Range() (low, high int)
+}
+
+// MaxExpandedRange is the widest range, in IDs, that the server registers
+// ID by ID.
+const MaxExpandedRange = 1 << 16
+
+// Matcher is implemented by routes that handle every message ID for which
+// Match returns true. Matching routes are consulted only for IDs that have
+// no exact (or ranged) route, in descending Priority order.
+type Matcher interface {
+	Match(msgID int) bool
+}
+
+type rangeRoute struct {
+	route
+	low, high int
+}
+
+func (r rangeRoute) Range() (low, high int) {
+	return r.low, r.high
+}
+
+type maskRoute struct {
+	route
+	mask, value int
+}
+
+func (r maskRoute) Match(msgID int) bool {
+	return msgID&r.mask == r.value
+}
+
+// NewRangeRoute creates a route handling every message ID from low to high
+// inclusive. Its ID() is low. opts are applied as for NewRoute. A range
+// with low greater than high is fatal; see Ranged for how wide ranges are
+// registered.
+//
+// Example:
+//
+//	router.NewRangeRoute("Inventory", 0x0200, 0x02FF, true, false, HandleInventory(), nil)
+func NewRangeRoute(
+	name string,
+	low, high int,
+	status, experimental bool,
+	handler handler.HandlerFunc,
+	middleware []func(handler.HandlerFunc) handler.HandlerFunc,
+	opts ...RouteWrapper,
+) Route {
+	if low > high {
+		log.Fatal().
+			Str("Function", "NewRangeRoute").
+			Str("Name", name).
+			Int("Low", low).
+			Int("High", high).
+			Msg("route range is empty, low must not exceed high")
+	}
+
+	return rangeRoute{
+		route: build(name, low, status, experimental, handler, middleware, opts),
+		low:   low,
+		high:  high,
+	}
+}
+
+// NewMaskRoute creates a route handling every message ID for which
+// id&mask == value, e.g. a category encoded in the high bits. Its ID() is
+// value. opts are applied as for NewRoute.
+//
+// Example:
+//
+//	// All IDs of the form 0x02XX
+//	router.NewMaskRoute("Inventory", 0xFF00, 0x0200, true, false, HandleInventory(), nil)
+func NewMaskRoute(
+	name string,
+	mask, value int,
+	status, experimental bool,
+	handler handler.HandlerFunc,
+	middleware []func(handler.HandlerFunc) handler.HandlerFunc,
+	opts ...RouteWrapper,
+) Route {
+	return maskRoute{
+		route: build(name, value, status, experimental, handler, middleware, opts),
+		mask:  mask,
+		value: value & mask,
+	}
+}
+
+// build applies opts to a new route. Options that replace the Route with a
+// different implementation are not supported here and are ignored.
+func build(
+	name string,
+	id int,
+	status, experimental bool,
+	handler handler.HandlerFunc,
+	middleware []func(handler.HandlerFunc) handler.HandlerFunc,
+	opts []RouteWrapper,
+) route {
+	rt := route{
+		name:         name,
+		id:           id,
+		status:       status,
+		experimental: experimental,
+		handler:      handler,
+		middleware:   middleware,
+	}
+	for _, o := range opts {
+		if r, ok := o(rt).(route); ok {
+			rt = r
+		}
+	}
+	return rt
+}
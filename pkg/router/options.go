@@ -0,0 +1,255 @@
+package router
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/etwodev/bmux/internal/logwriter"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/panjf2000/gnet/v2"
+	"github.com/rs/zerolog"
+)
+
+// logOut is the sink of the package logger, swapped by SetLogWriter.
+var logOut = logwriter.New(zerolog.ConsoleWriter{
+	Out:        os.Stdout,
+	TimeFormat: "2006-01-02T15:04:05",
+})
+
+var log = zerolog.New(logOut).With().Timestamp().Str("Group", "bmux-router").Logger()
+
+// SetLogWriter redirects the package logger to w. It is safe to call while
+// the server is running: each log line goes entirely to the old or new w.
+func SetLogWriter(w io.Writer) {
+	logOut.Store(w)
+}
+
+// --- Route options ---
+//
+// The options below are RouteWrappers for use with NewRoute. They only
+// apply to routes built by NewRoute; any other Route is returned unchanged.
+
+// WithPriority sets the route's registration priority (default 0). When
+// several enabled routes share an ID, the one with the highest priority is
+// the one that handles it.
+//
+// Example:
+//
+//	router.NewRoute("PingV2", 0x01, true, false, HandlePingV2(), nil, router.WithPriority(10))
+func WithPriority(priority int) RouteWrapper {
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok {
+			return r
+		}
+
+		rt.priority = priority
+		return rt
+	}
+}
+
+// WithActivationPredicate makes the route's status also depend on active,
+// e.g. a feature flag from an external source. active is evaluated
+// whenever the status is read, so in particular when the server registers
+// its routes on Start; toggling it afterwards has no effect until routes
+// are registered again.
+//
+// Example:
+//
+//	router.NewRoute("Trade", 0x40, true, false, HandleTrade(), nil,
+//		router.WithActivationPredicate(func() bool { return flags.Enabled("trading") }))
+func WithActivationPredicate(active func() bool) RouteWrapper {
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok {
+			return r
+		}
+
+		rt.predicate = active
+		return rt
+	}
+}
+
+// WithMaxConcurrency limits the route's handler to n concurrent invocations
+// across all connections.
+//
+// When the limit is reached and queue is true, the invocation waits for a
+// free slot. Note that handlers run on gnet event loops, so a queued
+// invocation blocks its event loop (and every connection on it) while it waits.
+//
+// Otherwise the invocation is rejected: a warning is logged and onReject,
+// if non-nil, runs in place of the handler, e.g. to write a "busy" reply.
+// A rejected invocation without onReject returns gnet.None.
+//
+// Example:
+//
+//	router.NewRoute("Export", 0x10, true, false, HandleExport(), nil,
+//		router.WithMaxConcurrency(4, false, ReplyBusy()))
+func WithMaxConcurrency(n int, queue bool, onReject handler.HandlerFunc) RouteWrapper {
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok || n <= 0 {
+			return r
+		}
+
+		sem := make(chan struct{}, n)
+		next := rt.handler
+		name, id := rt.name, rt.id
+
+		rt.handler = func(conn gnet.Conn, body []byte) gnet.Action {
+			if queue {
+				sem <- struct{}{}
+			} else {
+				select {
+				case sem <- struct{}{}:
+				default:
+					log.Warn().
+						Str("Name", name).
+						Int("RouteID", id).
+						Int("Limit", n).
+						Str("Remote", conn.RemoteAddr().String()).
+						Msg("route concurrency limit reached, rejecting message")
+
+					if onReject != nil {
+						return onReject(conn, body)
+					}
+					return gnet.None
+				}
+			}
+			defer func() { <-sem }()
+
+			return next(conn, body)
+		}
+		return rt
+	}
+}
+
+// WithMaxBodySize rejects messages whose body is longer than limit bytes
+// before the route's handler runs. This guards expensive handlers from
+// oversized inputs independently of any global frame limit.
+//
+// A rejected message is logged and onReject, if non-nil, runs in place of
+// the handler, e.g. to write an error reply. Without onReject the message
+// is dropped and gnet.None is returned.
+//
+// Example:
+//
+//	router.NewRoute("Upload", 0x20, true, false, HandleUpload(), nil,
+//		router.WithMaxBodySize(64<<10, ReplyTooLarge()))
+func WithMaxBodySize(limit int, onReject handler.HandlerFunc) RouteWrapper {
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok || limit < 0 {
+			return r
+		}
+
+		next := rt.handler
+		name, id := rt.name, rt.id
+
+		rt.handler = func(conn gnet.Conn, body []byte) gnet.Action {
+			if len(body) <= limit {
+				return next(conn, body)
+			}
+
+			log.Warn().
+				Str("Name", name).
+				Int("RouteID", id).
+				Int("Limit", limit).
+				Int("Size", len(body)).
+				Str("Remote", conn.RemoteAddr().String()).
+				Msg("message body exceeds route limit, rejecting message")
+
+			if onReject != nil {
+				return onReject(conn, body)
+			}
+			return gnet.None
+		}
+		return rt
+	}
+}
+
+// WithAllowedIPs restricts the route to peers whose source IP falls within
+// one of cidrs. Bare addresses ("10.0.0.5") are accepted as single-host
+// prefixes. The list is parsed once here; an invalid entry is fatal.
+//
+// A message from any other address is logged and onReject, if non-nil,
+// runs in place of the handler, e.g. to write an error reply. Without
+// onReject the message is dropped and gnet.None is returned.
+//
+// Example:
+//
+//	router.NewRoute("Drain", 0xF001, true, false, HandleDrain(), nil,
+//		router.WithAllowedIPs(ReplyForbidden(), "127.0.0.1", "10.0.0.0/8"))
+func WithAllowedIPs(onReject handler.HandlerFunc, cidrs ...string) RouteWrapper {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			log.Fatal().Str("Function", "WithAllowedIPs").Err(err).Msg("invalid allowed IP")
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return func(r Route) Route {
+		rt, ok := r.(route)
+		if !ok {
+			return r
+		}
+
+		next := rt.handler
+		name, id := rt.name, rt.id
+
+		rt.handler = func(conn gnet.Conn, body []byte) gnet.Action {
+			addr, ok := remoteIP(conn.RemoteAddr())
+			if ok {
+				for _, prefix := range prefixes {
+					if prefix.Contains(addr) {
+						return next(conn, body)
+					}
+				}
+			}
+
+			log.Warn().
+				Str("Name", name).
+				Int("RouteID", id).
+				Str("Remote", conn.RemoteAddr().String()).
+				Msg("source address not allowed for route, rejecting message")
+
+			if onReject != nil {
+				return onReject(conn, body)
+			}
+			return gnet.None
+		}
+		return rt
+	}
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func remoteIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}, false
+	}
+
+	parsed, ok := netip.AddrFromSlice(ip)
+	return parsed.Unmap(), ok
+}
@@ -0,0 +1,150 @@
+package router_test
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestAllowedIPsRunsOnReject(t *testing.T) {
+	handled, rejected := 0, 0
+	rt := router.NewRoute("Drain", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		handled++
+		return gnet.None
+	}, nil, router.WithAllowedIPs(func(c gnet.Conn, _ []byte) gnet.Action {
+		rejected++
+		_, _ = c.Write([]byte("forbidden"))
+		return gnet.Close
+	}, "127.0.0.1", "10.0.0.0/8"))
+	h := rt.Handler()
+
+	allowed := enginetest.NewConnFrom(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4000})
+	if action := h(allowed, nil); action != gnet.None || handled != 1 {
+		t.Fatalf("allowed peer: action %v, handled %d, want gnet.None and 1", action, handled)
+	}
+
+	other := enginetest.NewConnFrom(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000})
+	if action := h(other, nil); action != gnet.Close || rejected != 1 || handled != 1 {
+		t.Fatalf("other peer: action %v, rejected %d, handled %d, want gnet.Close, 1, 1", action, rejected, handled)
+	}
+	if got := string(other.Written()); got != "forbidden" {
+		t.Fatalf("reply to rejected peer = %q, want %q", got, "forbidden")
+	}
+}
+
+func TestMaxConcurrencyRejectsOverLimit(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	rejected := 0
+	rt := router.NewRoute("Export", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		entered <- struct{}{}
+		<-release
+		return gnet.None
+	}, nil, router.WithMaxConcurrency(2, false, func(gnet.Conn, []byte) gnet.Action {
+		rejected++
+		return gnet.Close
+	}))
+	h := rt.Handler()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h(enginetest.NewConn(), nil)
+		}()
+		<-entered
+	}
+
+	if action := h(enginetest.NewConn(), nil); action != gnet.Close || rejected != 1 {
+		t.Fatalf("third invocation: action %v, rejected %d, want gnet.Close and 1", action, rejected)
+	}
+
+	close(release)
+	wg.Wait()
+
+	go func() { <-entered }()
+	if action := h(enginetest.NewConn(), nil); action != gnet.None || rejected != 1 {
+		t.Fatalf("invocation after release: action %v, rejected %d, want gnet.None and 1", action, rejected)
+	}
+}
+
+func TestMaxConcurrencyQueues(t *testing.T) {
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	rt := router.NewRoute("Export", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		return gnet.None
+	}, nil, router.WithMaxConcurrency(1, true, nil))
+	h := rt.Handler()
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h(enginetest.NewConn(), nil)
+		}()
+	}
+	for range 3 {
+		release <- struct{}{}
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p != 1 {
+		t.Fatalf("peak concurrent invocations = %d, want the queue to hold it at 1", p)
+	}
+}
+
+func TestMaxBodySizeAtAndOverLimit(t *testing.T) {
+	handled, rejected := 0, 0
+	rt := router.NewRoute("Upload", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		handled++
+		return gnet.None
+	}, nil, router.WithMaxBodySize(4, func(c gnet.Conn, _ []byte) gnet.Action {
+		rejected++
+		_, _ = c.Write([]byte("too large"))
+		return gnet.None
+	}))
+	h := rt.Handler()
+
+	for _, body := range []string{"", "abc", "abcd"} {
+		h(enginetest.NewConn(), []byte(body))
+	}
+	if handled != 3 || rejected != 0 {
+		t.Fatalf("bodies up to the limit: handled %d, rejected %d, want 3, 0", handled, rejected)
+	}
+
+	c := enginetest.NewConn()
+	h(c, []byte("abcde"))
+	if handled != 3 || rejected != 1 {
+		t.Fatalf("body over the limit: handled %d, rejected %d, want 3, 1", handled, rejected)
+	}
+	if got := string(c.Written()); got != "too large" {
+		t.Fatalf("reply to oversized body = %q, want %q", got, "too large")
+	}
+}
+
+func TestMaxBodySizeDropsWithoutOnReject(t *testing.T) {
+	handled := false
+	rt := router.NewRoute("Upload", 1, true, false, func(gnet.Conn, []byte) gnet.Action {
+		handled = true
+		return gnet.Close
+	}, nil, router.WithMaxBodySize(0, nil))
+
+	if action := rt.Handler()(enginetest.NewConn(), []byte("x")); action != gnet.None || handled {
+		t.Fatalf("oversized body without onReject: action %v, handled %v, want gnet.None, false", action, handled)
+	}
+}
@@ -37,3 +37,11 @@ type Route interface {
 	// Middleware returns middleware applied only to this route.
 	Middleware() []func(handler.HandlerFunc) handler.HandlerFunc
 }
+
+// Prioritized is implemented by routes with a registration priority, such
+// as those built by NewRoute (see WithPriority). When several routes share
+// an ID the highest priority wins; a route that does not implement
+// Prioritized has priority 0.
+type Prioritized interface {
+	Priority() int
+}
@@ -0,0 +1,91 @@
+package bmux
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// freePort returns a TCP port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// pingPong dials addr, sends a frame for ID 1 and waits for its reply.
+func pingPong(t *testing.T, addr string) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write(frame(1, "ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := frame(2, "pong")
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+}
+
+// TestReusePortSharesListener runs two servers on one port, as during a
+// restart, and checks the port keeps serving once the first stops.
+func TestReusePortSharesListener(t *testing.T) {
+	cfg := testConfig()
+	cfg.Address = "127.0.0.1"
+	cfg.Port = freePort(t)
+	cfg.EnableMulticore = false
+	if err := config.LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &cfg); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	addr := net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))
+
+	start := func() func(context.Context) error {
+		s := New(newContext, extractLength, extractMsgID, nil, WithReusePort[testContext]())
+		s.LoadRouter(singleRouter(router.NewRoute("Ping", 1, true, false,
+			func(c gnet.Conn, _ []byte) gnet.Action {
+				_, _ = c.Write(frame(2, "pong"))
+				return gnet.None
+			}, nil)))
+
+		stop, err := s.StartAsync()
+		if err != nil {
+			t.Fatalf("StartAsync: %v", err)
+		}
+		return stop
+	}
+
+	stopOld := start()
+	stopNew := start()
+	defer func() { _ = stopNew(context.Background()) }()
+	pingPong(t, addr)
+
+	if err := stopOld(context.Background()); err != nil {
+		t.Fatalf("stopping the first server: %v", err)
+	}
+	for range 5 {
+		pingPong(t, addr)
+	}
+}
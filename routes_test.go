@@ -0,0 +1,355 @@
+package bmux
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/handler"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.LogLevel = "error"
+	return cfg
+}
+
+// TestRegisterRoutesWhileServing re-registers routes through Engine while
+// traffic is dispatched; run with -race.
+func TestRegisterRoutesWhileServing(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	s.LoadRouter(singleRouter(
+		router.NewRoute("Exact", 1, true, false, ok(), nil),
+		router.NewMaskRoute("Masked", 0xF0, 0x10, true, false, ok(), nil),
+	))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	c := tr.Dial()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			s.Engine()
+		}
+	}()
+
+	for i := range 200 {
+		tr.Send(c, frame(byte(0x10+i%2), "x"))
+		tr.Send(c, frame(0x02, "x"))
+	}
+	wg.Wait()
+}
+
+func TestTopRoutesCountsMatchedTraffic(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	s.LoadRouter(singleRouter(
+		router.NewRoute("Exact", 1, true, false, ok(), nil),
+		router.NewMaskRoute("Masked", 0xF0, 0x10, true, false, ok(), nil),
+	))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	c := tr.Dial()
+	for _, id := range []byte{0x11, 0x12, 0x13, 0x01} {
+		tr.Send(c, frame(id, ""))
+	}
+
+	top := s.TopRoutes(-1)
+	if len(top) != 2 {
+		t.Fatalf("TopRoutes(-1) = %v, want 2 routes", top)
+	}
+	if top[0].Name != "Masked" || top[0].ID != 0x10 || top[0].Count != 3 {
+		t.Errorf("TopRoutes(-1)[0] = %+v, want Masked 0x10 with 3 messages", top[0])
+	}
+	if top[1].Name != "Exact" || top[1].Count != 1 {
+		t.Errorf("TopRoutes(-1)[1] = %+v, want Exact with 1 message", top[1])
+	}
+}
+
+func TestRangeRouteEndingAtMaxInt(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	s.LoadRouter(singleRouter(router.NewRangeRoute("Top", math.MaxInt-1, math.MaxInt, true, false, ok(), nil)))
+
+	if n := len(s.Engine().Handlers()); n != 2 {
+		t.Fatalf("registered %d IDs, want 2", n)
+	}
+}
+
+func TestExpectedRoutesSizesHandlerTable(t *testing.T) {
+	var sizes []int
+	defer func(orig func(int) map[int]handler.HandlerFunc) { newHandlerTable = orig }(newHandlerTable)
+	newHandlerTable = func(size int) map[int]handler.HandlerFunc {
+		sizes = append(sizes, size)
+		return make(map[int]handler.HandlerFunc, size)
+	}
+
+	s, _ := newServer(t, testConfig(), WithExpectedRoutes[testContext](4096))
+	s.LoadRouter(singleRouter(
+		router.NewRoute("Exact", 1, true, false, ok(), nil),
+		router.NewRangeRoute("Range", 0x10, 0x1F, true, false, ok(), nil),
+	))
+
+	if n := len(s.Engine().Handlers()); n != 17 {
+		t.Fatalf("registered %d IDs, want 17", n)
+	}
+	if len(sizes) != 1 || sizes[0] != 4096 {
+		t.Fatalf("handler table sizes = %v, want [4096]", sizes)
+	}
+}
+
+// plainRoute implements router.Route without router.Prioritized.
+type plainRoute struct{ h handler.HandlerFunc }
+
+func (plainRoute) ID() int                                                     { return 1 }
+func (plainRoute) Name() string                                                { return "Plain" }
+func (r plainRoute) Handler() handler.HandlerFunc                              { return r.h }
+func (plainRoute) Status() bool                                                { return true }
+func (plainRoute) Experimental() bool                                          { return false }
+func (plainRoute) Middleware() []func(handler.HandlerFunc) handler.HandlerFunc { return nil }
+
+func TestDuplicateIDsResolvedByPriority(t *testing.T) {
+	var got string
+	record := func(name string) handler.HandlerFunc {
+		return func(gnet.Conn, []byte) gnet.Action {
+			got = name
+			return gnet.None
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		routes []router.Route
+		want   string
+	}{
+		{"higher priority loaded first", []router.Route{
+			router.NewRoute("High", 1, true, false, record("High"), nil, router.WithPriority(10)),
+			router.NewRoute("Low", 1, true, false, record("Low"), nil),
+		}, "High"},
+		{"higher priority loaded last", []router.Route{
+			router.NewRoute("Low", 1, true, false, record("Low"), nil, router.WithPriority(-1)),
+			router.NewRoute("High", 1, true, false, record("High"), nil),
+		}, "High"},
+		{"equal priority, last loaded wins", []router.Route{
+			router.NewRoute("First", 1, true, false, record("First"), nil),
+			router.NewRoute("Second", 1, true, false, record("Second"), nil),
+		}, "Second"},
+		{"route without a priority counts as 0", []router.Route{
+			router.NewRoute("Prioritized", 1, true, false, record("Prioritized"), nil, router.WithPriority(1)),
+			plainRoute{record("Plain")},
+		}, "Prioritized"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newServer(t, testConfig())
+			s.LoadRouter(singleRouter(tc.routes...))
+
+			got = ""
+			enginetest.Traffic(s.Engine(), enginetest.NewConn(), frame(1, ""))
+			if got != tc.want {
+				t.Fatalf("ID 1 handled by %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWideRangeRouteIsMatched(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+	var got string
+	record := func(name string) handler.HandlerFunc {
+		return func(gnet.Conn, []byte) gnet.Action {
+			got = name
+			return gnet.None
+		}
+	}
+	s.LoadRouter(singleRouter(
+		router.NewRangeRoute("Wide", 0, 1<<31, true, false, record("Wide"), nil),
+		router.NewRoute("Exact", 7, true, false, record("Exact"), nil),
+	))
+
+	e := s.Engine()
+	if n := len(e.Handlers()); n != 1 {
+		t.Fatalf("registered %d IDs, want only the exact route", n)
+	}
+
+	c := enginetest.NewConn()
+	for id, want := range map[byte]string{7: "Exact", 200: "Wide"} {
+		got = ""
+		enginetest.Traffic(e, c, frame(id, ""))
+		if got != want {
+			t.Errorf("ID %d handled by %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestRangeRouteLatencyReportedOnce(t *testing.T) {
+	s, _ := newServer(t, testConfig(), WithLatencyStats[testContext]())
+	s.LoadRouter(singleRouter(router.NewRangeRoute("Inventory", 0x20, 0x2F, true, false, ok(), nil)))
+
+	e, c := s.Engine(), enginetest.NewConn()
+	for id := byte(0x20); id <= 0x2F; id++ {
+		enginetest.Traffic(e, c, frame(id, ""))
+	}
+
+	stats := s.LatencyStats()
+	if len(stats) != 1 || stats[0x20].Count != 16 {
+		t.Fatalf("LatencyStats() = %v, want 16 messages under 0x20 only", stats)
+	}
+}
+
+func TestActivationPredicateEvaluatedOncePerRegistration(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+
+	active, calls := true, 0
+	s.LoadRouter(singleRouter(
+		router.NewRoute("Flagged", 1, true, false, ok(), nil,
+			router.WithActivationPredicate(func() bool { calls++; return active })),
+	))
+
+	if n := s.registerRoutes(); n != 1 {
+		t.Fatalf("registered %d routes with the predicate true, want 1", n)
+	}
+	if calls != 1 {
+		t.Fatalf("predicate called %d times during registration, want 1", calls)
+	}
+
+	active = false
+	if n := s.registerRoutes(); n != 0 {
+		t.Fatalf("registered %d routes with the predicate false, want 0", n)
+	}
+
+	active = true
+	if n := s.registerRoutes(); n != 1 {
+		t.Fatalf("registered %d routes after re-enabling, want 1", n)
+	}
+	if calls != 3 {
+		t.Fatalf("predicate called %d times over 3 registrations, want 3", calls)
+	}
+}
+
+func TestStartWithoutRoutesWarns(t *testing.T) {
+	cfg := testConfig()
+	cfg.LogLevel = "warn"
+	s, _ := newServer(t, cfg)
+	logs := captureLog(t)
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync without routes: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	if !strings.Contains(logs.String(), "no routes registered") {
+		t.Fatalf("no warning logged for a server without routes:\n%s", logs)
+	}
+}
+
+func TestStrictRoutingRefusesEmptyHandlers(t *testing.T) {
+	cfg := testConfig()
+	cfg.StrictRouting = true
+	s, _ := newServer(t, cfg)
+	s.LoadRouter(singleRouter(router.NewRoute("Disabled", 1, false, false, ok(), nil)))
+
+	if stop, err := s.StartAsync(); err == nil {
+		_ = stop(context.Background())
+		t.Fatal("StartAsync succeeded without active routes in strict routing mode")
+	}
+}
+
+func TestRouteNamesInLogs(t *testing.T) {
+	cfg := testConfig()
+	cfg.LogLevel = "debug"
+	cfg.LogHandlerCloses = true
+	s, tr := newServer(t, cfg)
+	closes := func(gnet.Conn, []byte) gnet.Action { return gnet.Close }
+	s.LoadRouter(singleRouter(
+		router.NewRoute("Logout", 1, true, false, closes, nil),
+		router.NewMaskRoute("Admin", 0xF0, 0xA0, true, false, closes, nil),
+	))
+	logs := captureLog(t)
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	tr.Send(tr.Dial(), frame(1, ""))
+	tr.Send(tr.Dial(), frame(0xA7, ""))
+	tr.Send(tr.Dial(), frame(0x42, ""))
+
+	for _, want := range []string{
+		`"msgID":1,"route":"Logout"`,
+		`"msgID":167,"route":"Admin"`,
+		`"msgID":66,"route":"unknown"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs miss %s:\n%s", want, logs)
+		}
+	}
+}
+
+func TestMaxRoutes(t *testing.T) {
+	routes := func() []router.Router {
+		return singleRouter(
+			router.NewRoute("A", 1, true, false, ok(), nil),
+			router.NewRoute("B", 2, true, false, ok(), nil),
+			router.NewRoute("C", 3, true, false, ok(), nil),
+		)
+	}
+
+	cfg := testConfig()
+	cfg.LogLevel = "warn"
+	cfg.MaxRoutes = 2
+	s, _ := newServer(t, cfg)
+	s.LoadRouter(routes())
+	logs := captureLog(t)
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync over maxRoutes: %v", err)
+	}
+	_ = stop(context.Background())
+	if !strings.Contains(logs.String(), "registered routes exceed maxRoutes") {
+		t.Fatalf("no warning logged over maxRoutes:\n%s", logs)
+	}
+
+	cfg.MaxRoutes = 3
+	s, _ = newServer(t, cfg)
+	s.LoadRouter(routes())
+	logs = captureLog(t)
+	stop, err = s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync at maxRoutes: %v", err)
+	}
+	_ = stop(context.Background())
+	if strings.Contains(logs.String(), "maxRoutes") {
+		t.Fatalf("warning logged at exactly maxRoutes:\n%s", logs)
+	}
+
+	cfg.MaxRoutes = 2
+	cfg.StrictRouting = true
+	s, _ = newServer(t, cfg)
+	s.LoadRouter(routes())
+	if stop, err := s.StartAsync(); err == nil {
+		_ = stop(context.Background())
+		t.Fatal("StartAsync succeeded over maxRoutes in strict routing mode")
+	} else if !strings.Contains(err.Error(), "exceeding maxRoutes 2") {
+		t.Fatalf("StartAsync = %v, want the maxRoutes error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+)
+
+func TestShutdownHooksRunLIFO(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+
+	var order []int
+	errHook := errors.New("flush failed")
+	for i := range 3 {
+		s.OnShutdown(func(context.Context) error {
+			order = append(order, i)
+			if i == 1 {
+				return errHook
+			}
+			return nil
+		})
+	}
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+
+	err = stop(context.Background())
+	if !errors.Is(err, errHook) {
+		t.Fatalf("stop = %v, want the hook error", err)
+	}
+	if want := []int{2, 1, 0}; !slices.Equal(order, want) {
+		t.Fatalf("hooks ran in order %v, want %v", order, want)
+	}
+}
+
+func TestShutdownHooksSkippedOnceContextDone(t *testing.T) {
+	s, _ := newServer(t, testConfig())
+
+	var ran bool
+	s.OnShutdown(func(context.Context) error {
+		ran = true
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := s.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Shutdown = %v, want context.Canceled", err)
+	}
+	if ran {
+		t.Fatal("hook ran after the context was done")
+	}
+}
+
+func TestGoodbyeFrameSentBeforeClose(t *testing.T) {
+	goodbye := frame(0xFD, "moving to another server")
+	s, tr := newServer(t, testConfig(), WithGoodbyeFrame[testContext](goodbye))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+
+	conns := []*enginetest.Conn{tr.Dial(), tr.Dial()}
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	// Writes to a closed Conn fail, so the frame arriving means it was
+	// written before the close.
+	for i, c := range conns {
+		if got := c.Written(); !bytes.Equal(got, goodbye) {
+			t.Errorf("connection %d received %q, want the goodbye frame", i, got)
+		}
+		if !c.Closed() {
+			t.Errorf("connection %d still open after Shutdown", i)
+		}
+	}
+}
+
+func TestShutdownSignalsCustomSet(t *testing.T) {
+	// Keep SIGUSR1 from killing the test binary should it arrive before
+	// StartE subscribes.
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGUSR1)
+	defer signal.Stop(guard)
+
+	s, tr := newServer(t, testConfig(), WithShutdownSignals[testContext](syscall.SIGUSR1))
+	hooked := make(chan struct{})
+	s.OnShutdown(func(context.Context) error {
+		close(hooked)
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- s.StartE() }()
+	<-tr.Ready()
+
+	for range 100 {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatalf("Kill: %v", err)
+		}
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("StartE = %v after the shutdown signal, want nil", err)
+			}
+			select {
+			case <-hooked:
+			default:
+				t.Fatal("StartE returned without running the shutdown hooks")
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatal("StartE still running after repeated shutdown signals")
+}
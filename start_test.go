@@ -0,0 +1,86 @@
+package bmux
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etwodev/bmux/pkg/config"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+func TestStartEReturnsBindError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer taken.Close()
+
+	cfg := testConfig()
+	cfg.Address = "127.0.0.1"
+	cfg.Port = taken.Addr().(*net.TCPAddr).Port
+	cfg.EnableMulticore = false
+	if err := config.LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &cfg); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+
+	s := New(newContext, extractLength, extractMsgID, nil)
+	s.LoadRouter(singleRouter(router.NewRoute("Ping", 1, true, false, ok(), nil)))
+
+	done := make(chan error, 1)
+	go func() { done <- s.StartE() }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "address already in use") {
+			t.Fatalf("StartE = %v, want the bind error", err)
+		}
+		if !strings.HasPrefix(err.Error(), "StartE: ") {
+			t.Errorf("StartE error %q is not prefixed with the function name", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartE kept running on a port that is already taken")
+	}
+}
+
+func TestStartAsyncServesUntilStopped(t *testing.T) {
+	cfg := testConfig()
+	cfg.Address = "127.0.0.1"
+	cfg.Port = freePort(t)
+	cfg.EnableMulticore = false
+	if err := config.LoadFrom(filepath.Join(t.TempDir(), "bmux.config.json"), &cfg); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	addr := net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))
+
+	s := New(newContext, extractLength, extractMsgID, nil)
+	s.LoadRouter(singleRouter(router.NewRoute("Ping", 1, true, false,
+		func(c gnet.Conn, _ []byte) gnet.Action {
+			_, _ = c.Write(frame(2, "pong"))
+			return gnet.None
+		}, nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+
+	// StartAsync returns once the listener accepts connections.
+	pingPong(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("listener still accepting after stop")
+	}
+}
@@ -0,0 +1,56 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+)
+
+func TestBroadcastToReachesOnlyTaggedConnections(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	a, b, other := tr.Dial(), tr.Dial(), tr.Dial()
+	s.SetTags(a, "room:1")
+	s.SetTags(b, "room:1", "room:2")
+	s.SetTags(other, "room:2")
+
+	packet := frame(0, "hello")
+	if err := s.BroadcastTo("room:1", packet); err != nil {
+		t.Fatalf("BroadcastTo: %v", err)
+	}
+
+	for i, c := range []*enginetest.Conn{a, b} {
+		if got := c.Written(); !bytes.Equal(got, packet) {
+			t.Errorf("tagged connection %d received %q, want %q", i, got, packet)
+		}
+	}
+	if got := other.Written(); len(got) != 0 {
+		t.Errorf("untagged connection received %q", got)
+	}
+
+	// SetTags replaces the previous tags, and closed connections leave
+	// their groups.
+	s.SetTags(b, "room:2")
+	tr.Hangup(a)
+	if err := s.BroadcastTo("room:1", packet); err != nil {
+		t.Fatalf("BroadcastTo: %v", err)
+	}
+	if got := b.Written(); !bytes.Equal(got, packet) {
+		t.Errorf("retagged connection received %q, want only the first broadcast", got)
+	}
+
+	if got, want := s.Engine().Tags(b), []string{"room:2"}; !slices.Equal(got, want) {
+		t.Errorf("Tags = %v, want %v", got, want)
+	}
+	if got := s.Engine().Tagged("room:1"); len(got) != 0 {
+		t.Errorf("Tagged(room:1) = %d connections after they left, want 0", len(got))
+	}
+}
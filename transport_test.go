@@ -0,0 +1,59 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// failingTransport fails to run, as a listener that cannot bind does.
+type failingTransport struct{ err error }
+
+func (t failingTransport) Run(gnet.EventHandler, []string, ...gnet.Option) error { return t.err }
+
+func (failingTransport) Stop(context.Context) error { return nil }
+
+func TestCustomTransportServes(t *testing.T) {
+	tr := &recordingTransport{Transport: enginetest.NewTransport()}
+	s, _ := newServer(t, testConfig(), WithTransport[testContext](tr))
+	s.LoadRouter(singleRouter(router.NewRoute("Echo", 1, true, false,
+		func(c gnet.Conn, body []byte) gnet.Action {
+			_, _ = c.Write(frame(0, string(body)))
+			return gnet.None
+		}, nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+
+	c := tr.Dial()
+	tr.Send(c, frame(1, "ping"))
+	if got, want := c.Written(), frame(0, "ping"); !bytes.Equal(got, want) {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+	if len(tr.Addrs()) == 0 {
+		t.Fatal("transport run without listen addresses")
+	}
+
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if !c.Closed() {
+		t.Fatal("connection still open after the transport stopped")
+	}
+}
+
+func TestTransportRunErrorReturned(t *testing.T) {
+	errBind := errors.New("bind: address already in use")
+	s, _ := newServer(t, testConfig(), WithTransport[testContext](failingTransport{errBind}))
+
+	if _, err := s.StartAsync(); !errors.Is(err, errBind) {
+		t.Fatalf("StartAsync = %v, want the transport error", err)
+	}
+}
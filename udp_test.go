@@ -0,0 +1,29 @@
+package bmux
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/enginetest"
+)
+
+func TestUDPPortAddsListener(t *testing.T) {
+	cfg := testConfig()
+	cfg.Address = "127.0.0.1"
+	cfg.Port = 40000
+	cfg.UDPPort = 40001
+
+	tr := &recordingTransport{Transport: enginetest.NewTransport()}
+	s, _ := newServer(t, cfg, WithTransport[testContext](tr))
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	want := []string{"tcp://127.0.0.1:40000", "udp://127.0.0.1:40001"}
+	if got := tr.Addrs(); !slices.Equal(got, want) {
+		t.Fatalf("listen addresses = %v, want %v", got, want)
+	}
+}
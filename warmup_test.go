@@ -0,0 +1,45 @@
+package bmux
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWarmupRefusesUntilReady(t *testing.T) {
+	retry := frame(0xFE, "warming up")
+	s, tr := newServer(t, testConfig(), WithWarmup[testContext](retry))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	refused := tr.Dial()
+	if !refused.Closed() {
+		t.Fatal("connection accepted while warming up")
+	}
+	if got := refused.Written(); !bytes.Equal(got, retry) {
+		t.Fatalf("refused connection received %q, want the warmup reply %q", got, retry)
+	}
+	if n := atomic.LoadInt64(&s.engineWrapper.ActiveConnections); n != 0 {
+		t.Fatalf("ActiveConnections = %d after a refusal, want 0", n)
+	}
+
+	s.SetReady(true)
+	accepted := tr.Dial()
+	if accepted.Closed() {
+		t.Fatal("connection refused after SetReady(true)")
+	}
+
+	// Going back to warming up leaves open connections alone.
+	s.SetReady(false)
+	if accepted.Closed() {
+		t.Fatal("open connection closed by SetReady(false)")
+	}
+	if !tr.Dial().Closed() {
+		t.Fatal("connection accepted after SetReady(false)")
+	}
+}
@@ -0,0 +1,72 @@
+package bmux
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/etwodev/bmux/pkg/router"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// TestConcurrentWritersKeepFramesIntact writes to one connection from its
+// handler, Send and BroadcastTo at once; run with -race.
+func TestConcurrentWritersKeepFramesIntact(t *testing.T) {
+	s, tr := newServer(t, testConfig())
+	reply := frame(0, "reply")
+	s.LoadRouter(singleRouter(router.NewRoute("Reply", 1, true, false,
+		func(c gnet.Conn, _ []byte) gnet.Action {
+			_, _ = c.Writev([][]byte{reply[:3], reply[3:]})
+			return gnet.None
+		}, nil)))
+
+	stop, err := s.StartAsync()
+	if err != nil {
+		t.Fatalf("StartAsync: %v", err)
+	}
+	defer func() { _ = stop(context.Background()) }()
+
+	c := tr.Dial()
+	s.SetTags(c, "room")
+
+	const n = 300
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range n {
+			_ = s.Send(c, frame(0, "send"))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range n {
+			_ = s.BroadcastTo("room", frame(0, "broadcast"))
+		}
+	}()
+	for range n {
+		tr.Send(c, frame(1, ""))
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	out := c.Written()
+	for len(out) > 0 {
+		if len(out) < 3 {
+			t.Fatalf("%d trailing bytes after the last whole frame", len(out))
+		}
+		size := 3 + int(out[0]) + int(binary.LittleEndian.Uint16(out[1:3]))
+		if len(out) < size {
+			t.Fatalf("truncated frame: %d of %d bytes", len(out), size)
+		}
+		counts[string(out[4:size])]++
+		out = out[size:]
+	}
+
+	for _, body := range []string{"reply", "send", "broadcast"} {
+		if counts[body] != n {
+			t.Errorf("%d intact %q frames, want %d (all frames: %v)", counts[body], body, n, counts)
+		}
+	}
+}